@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -14,17 +15,68 @@ import (
 func main() {
 	mode := flag.Int("mode", 2, "查询模式: 1=单线程 2=多线程")
 	verbose := flag.Bool("verbose", false, "是否输出详细日志")
+	maxDistance := flag.Float64("max-distance", 0, "最大匹配距离(km)，超出视为无匹配，0 表示不限")
 	httpAddr := flag.String("http", "8080", "HTTP监听地址(例如 :8080，留空则执行单次查询模式，默认8080)")
+	strict := flag.Bool("strict", false, "严格加载：数据集中任何一行无效即退出")
+	saveSnapshot := flag.String("save-snapshot", "", "加载完成后写出二进制快照到该路径后退出(写到 data/rg_cities1000.snap 可加速后续启动)")
+	dataDir := flag.String("data-dir", filepath.Join(".", "data"), "数据目录")
+	dataset := flag.String("dataset", "cities1000", "数据集: cities500|cities1000|cities5000|cities15000|allCountries，或 countries:DE,FR")
+	watch := flag.Duration("watch", 0, "每隔该时间检查数据目录中的数据集，变化后自动热加载(如 30s，0 表示不监视)")
+	reloadToken := flag.String("reload-token", "", "POST /admin/reload 所需的 Bearer 令牌；为空时只接受本机请求")
+	updateInterval := flag.Duration("update-interval", 0, "每隔该时间从 GeoNames 下载并重新生成数据集，校验通过后热加载(如 24h，0 表示不更新)")
+	updateMinRows := flag.Int("update-min-rows", 0, "更新时新数据集至少的行数，0 表示不少于当前数据的 90%")
 	flag.Parse()
 
+	ds, err := rgeocoder.ParseDataset(*dataset)
+	if err != nil {
+		log.Fatalf("参数错误: %v", err)
+	}
 	rg, err := rgeocoder.NewRGeocoder(
 		rgeocoder.WithMode(rgeocoder.QueryMode(*mode)),
 		rgeocoder.WithVerbose(*verbose),
+		rgeocoder.WithMaxDistance(*maxDistance),
+		rgeocoder.WithStrictLoad(*strict),
+		rgeocoder.WithDataDir(*dataDir),
+		rgeocoder.WithDataset(ds),
+		rgeocoder.WithWatch(*watch),
+		rgeocoder.WithReloadHook(func(err error) {
+			if err != nil {
+				log.Printf("热加载失败，继续使用当前数据: %v", err)
+			} else {
+				log.Printf("数据集已热加载")
+			}
+		}),
 	)
 	if err != nil {
 		log.Fatalf("初始化失败: %v", err)
 	}
 	defer rg.Close()
+	if report := rg.LoadReport(); report != nil && report.Rejected > 0 {
+		log.Printf("数据集中有 %d 行被跳过: %s", report.Rejected, report)
+	}
+
+	if *saveSnapshot != "" {
+		if err := rg.SaveSnapshot(*saveSnapshot); err != nil {
+			log.Fatalf("写出快照失败: %v", err)
+		}
+		fmt.Println("snapshot written:", *saveSnapshot)
+		return
+	}
+
+	if *updateInterval > 0 {
+		u := rgeocoder.NewUpdater(rg)
+		u.Policy.MinRows = *updateMinRows
+		u.OnResult = func(res rgeocoder.UpdateResult) {
+			switch {
+			case res.Err != nil:
+				log.Printf("数据更新失败，保留当前数据: %v", res.Err)
+			case res.Changed:
+				log.Printf("数据已更新: %d 行，用时 %v", res.Rows, res.Finished.Sub(res.Started))
+			}
+		}
+		u.Start(*updateInterval)
+		defer u.Stop()
+	}
 
 	if *httpAddr != "" {
 		addr := *httpAddr
@@ -34,7 +86,7 @@ func main() {
 		} else if strings.HasPrefix(addr, ":") && len(addr) == 1 { // 防止传入仅":"
 			addr = ":8080"
 		}
-		if err := runHTTPServer(rg, addr); err != nil {
+		if err := runHTTPServer(newAPIServer(rg, *dataDir, *reloadToken), addr); err != nil {
 			log.Fatalf("HTTP服务启动失败: %v", err)
 		}
 		return
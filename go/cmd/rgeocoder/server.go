@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,15 +19,21 @@ import (
 
 // apiServer 封装 HTTP 逻辑
 type apiServer struct {
-	geo *rgeocoder.RGeocoder
+	geo         *rgeocoder.RGeocoder
+	dataDir     string
+	reloadToken string // 为空时 /admin/reload 只接受本机请求
 }
 
-func newAPIServer(geo *rgeocoder.RGeocoder) *apiServer { return &apiServer{geo: geo} }
+func newAPIServer(geo *rgeocoder.RGeocoder, dataDir, reloadToken string) *apiServer {
+	return &apiServer{geo: geo, dataDir: dataDir, reloadToken: reloadToken}
+}
 
 func (s *apiServer) register(r *gin.Engine) {
 	r.GET("/health", s.health)
 	r.GET("/reverse", s.reverse)
 	r.POST("/batch", s.batch)
+	r.GET("/nearby", s.nearby)
+	r.POST("/admin/reload", s.reload)
 }
 
 // 统一响应结构
@@ -56,12 +69,79 @@ func (s *apiServer) reverse(c *gin.Context) {
 		respondError(c, 40002, "invalid lat or lon")
 		return
 	}
-	loc, err := s.geo.QuerySingle(rgeocoder.Coordinate{Lat: lat, Lon: lon})
+	loc, err := s.geo.QuerySingleContext(c.Request.Context(), rgeocoder.Coordinate{Lat: lat, Lon: lon})
+	if errors.Is(err, rgeocoder.ErrNoMatch) {
+		respond(c, 0, "no match", pointResult{Status: statusNoMatch})
+		return
+	}
 	if err != nil {
 		respondError(c, 50001, err.Error())
 		return
 	}
-	respond(c, 0, "success", loc)
+	respond(c, 0, "success", pointResult{Location: &loc, Status: statusOK})
+}
+
+const (
+	statusOK      = "ok"
+	statusNoMatch = "no_match"
+)
+
+// pointResult 单点结果：匹配时平铺 Location 字段，无匹配时只有 status
+type pointResult struct {
+	*rgeocoder.Location
+	Status string `json:"status"`
+}
+
+// /nearby 的上限，避免一次请求返回整个数据集
+const (
+	maxNearbyRadius = 500.0 // km
+	maxNearbyLimit  = 1000
+)
+
+// /nearby?lat=..&lon=..&radius=..(km，不超过 maxNearbyRadius)&limit=..(可选，0 或省略时为 maxNearbyLimit，不得超过)
+func (s *apiServer) nearby(c *gin.Context) {
+	latStr := c.Query("lat")
+	lonStr := c.Query("lon")
+	radiusStr := c.Query("radius")
+	if latStr == "" || lonStr == "" || radiusStr == "" {
+		respondError(c, 40001, "missing lat, lon or radius")
+		return
+	}
+	lat, err1 := strconv.ParseFloat(latStr, 64)
+	lon, err2 := strconv.ParseFloat(lonStr, 64)
+	if err1 != nil || err2 != nil {
+		respondError(c, 40002, "invalid lat or lon")
+		return
+	}
+	radius, err := strconv.ParseFloat(radiusStr, 64)
+	if err != nil || radius < 0 {
+		respondError(c, 40003, "invalid radius")
+		return
+	}
+	if radius > maxNearbyRadius {
+		respondError(c, 40005, fmt.Sprintf("radius exceeds maximum of %g km", maxNearbyRadius))
+		return
+	}
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			respondError(c, 40004, "invalid limit")
+			return
+		}
+	}
+	if limit > maxNearbyLimit {
+		respondError(c, 40006, fmt.Sprintf("limit exceeds maximum of %d", maxNearbyLimit))
+		return
+	}
+	if limit == 0 {
+		limit = maxNearbyLimit
+	}
+	results, err := s.geo.QueryRadius(rgeocoder.Coordinate{Lat: lat, Lon: lon}, radius, limit)
+	if err != nil {
+		respondError(c, 50003, err.Error())
+		return
+	}
+	respond(c, 0, "success", results)
 }
 
 type batchRequest struct {
@@ -83,17 +163,79 @@ func (s *apiServer) batch(c *gin.Context) {
 	for _, p := range req.Points {
 		coords = append(coords, rgeocoder.Coordinate{Lat: p.Lat, Lon: p.Lon})
 	}
-	locs, err := s.geo.Query(coords)
+	details, err := s.geo.QueryDetailedContext(c.Request.Context(), coords)
+	if errors.Is(err, context.Canceled) {
+		// 客户端已断开，不再写响应
+		c.Abort()
+		return
+	}
 	if err != nil {
 		respondError(c, 50002, err.Error())
 		return
 	}
-	respond(c, 0, "success", locs)
+	results := make([]pointResult, len(details))
+	for i := range details {
+		if details[i].Matched {
+			results[i] = pointResult{Location: &details[i].Location, Status: statusOK}
+		} else {
+			results[i] = pointResult{Status: statusNoMatch}
+		}
+	}
+	respond(c, 0, "success", results)
+}
+
+type reloadRequest struct {
+	File string `json:"file"` // 数据目录下的文件名（CSV 或 .snap），为空时按启动时的规则重新加载
+}
+
+// /admin/reload 原子替换数据集，进行中的请求不受影响
+func (s *apiServer) reload(c *gin.Context) {
+	if !s.reloadAllowed(c) {
+		c.JSON(http.StatusForbidden, apiResponse{Code: 40301, Message: "forbidden"})
+		return
+	}
+	var req reloadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, 40020, "invalid body")
+			return
+		}
+	}
+	src := rgeocoder.DataDirSource()
+	if req.File != "" {
+		if !filepath.IsLocal(req.File) {
+			respondError(c, 40021, "file must be a relative path inside the data directory")
+			return
+		}
+		src = rgeocoder.FileSource(filepath.Join(s.dataDir, req.File))
+	}
+	if err := s.geo.Reload(src); err != nil {
+		respondError(c, 50010, err.Error())
+		return
+	}
+	data := gin.H{"locations": s.geo.Len()}
+	if report := s.geo.LoadReport(); report != nil {
+		data["rejected"] = report.Rejected
+	}
+	respond(c, 0, "reloaded", data)
+}
+
+// reloadAllowed 配置了令牌时校验 Authorization: Bearer <token>，否则只允许回环地址
+func (s *apiServer) reloadAllowed(c *gin.Context) bool {
+	if s.reloadToken != "" {
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(got), []byte(s.reloadToken)) == 1
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
-func runHTTPServer(geo *rgeocoder.RGeocoder, addr string) error {
+func runHTTPServer(s *apiServer, addr string) error {
 	r := gin.Default()
-	s := newAPIServer(geo)
 	s.register(r)
 	log.Printf("HTTP server listening on %s", addr)
 	return r.Run(addr)
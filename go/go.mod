@@ -2,7 +2,10 @@ module github.com/your-username/reverse-geocoder-go
 
 go 1.21
 
-require github.com/gin-gonic/gin v1.10.0
+require (
+	github.com/gin-gonic/gin v1.10.0
+	github.com/klauspost/compress v1.17.11
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
@@ -0,0 +1,132 @@
+package rgeocoder
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxNesting 压缩嵌套的最大层数（例如 zip 内的 .csv.gz）
+const maxNesting = 2
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicZip  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// openDecompressed 按魔数识别 gzip / zstd / zip 并返回解压后的内容，未压缩的输入原样返回。
+// zip 取其中唯一的文件，或第一个 .csv（可再压缩）条目。
+func openDecompressed(r io.Reader) (io.ReadCloser, error) {
+	return decompress(r, 0)
+}
+
+func decompress(r io.Reader, depth int) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(magicZip))
+	if depth > maxNesting {
+		return io.NopCloser(br), nil
+	}
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return nestedReader(zr, depth)
+	case bytes.HasPrefix(head, magicZstd):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return nestedReader(zr.IOReadCloser(), depth)
+	case bytes.HasPrefix(head, magicZip):
+		ra, size, err := readerAt(r, br)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return nil, fmt.Errorf("zip: %w", err)
+		}
+		entry, err := pickZipEntry(zr)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("zip %s: %w", entry.Name, err)
+		}
+		return nestedReader(rc, depth)
+	}
+	return io.NopCloser(br), nil
+}
+
+// nestedReader 对解压结果再做一次识别，关闭时一并关闭外层
+func nestedReader(rc io.ReadCloser, depth int) (io.ReadCloser, error) {
+	inner, err := decompress(rc, depth+1)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &stackedCloser{ReadCloser: inner, outer: rc}, nil
+}
+
+type stackedCloser struct {
+	io.ReadCloser
+	outer io.Closer
+}
+
+func (s *stackedCloser) Close() error {
+	err := s.ReadCloser.Close()
+	if cerr := s.outer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readerAt zip 需要随机访问：文件直接使用，其他流读入内存
+func readerAt(r io.Reader, br *bufio.Reader) (io.ReaderAt, int64, error) {
+	if f, ok := r.(interface {
+		io.ReaderAt
+		Stat() (fs.FileInfo, error)
+	}); ok {
+		if fi, err := f.Stat(); err == nil {
+			return f, fi.Size(), nil
+		}
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// pickZipEntry 唯一的文件条目，否则第一个 .csv / .csv.gz / .csv.zst 条目
+func pickZipEntry(zr *zip.Reader) (*zip.File, error) {
+	var files []*zip.File
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 1 {
+		return files[0], nil
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(strings.TrimSuffix(path.Base(f.Name), ".gz"), ".zst")
+		if strings.HasSuffix(name, ".csv") {
+			return f, nil
+		}
+	}
+	return nil, errors.New("zip: no csv entry found")
+}
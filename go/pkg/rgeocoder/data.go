@@ -1,6 +1,12 @@
 package rgeocoder
 
-import "time"
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
 
 // Coordinate 表示地理坐标
 type Coordinate struct {
@@ -8,7 +14,7 @@ type Coordinate struct {
 	Lon float64 `json:"lon"`
 }
 
-// Location 表示地理位置信息（与Python版本rg_cities1000.csv列一致）
+// Location 表示地理位置信息（前 6 个字段与Python版本rg_cities1000.csv列一致）
 type Location struct {
 	Lat    string `json:"lat" csv:"lat"`
 	Lon    string `json:"lon" csv:"lon"`
@@ -16,6 +22,57 @@ type Location struct {
 	Admin1 string `json:"admin1" csv:"admin1"`
 	Admin2 string `json:"admin2" csv:"admin2"`
 	CC     string `json:"cc" csv:"cc"`
+
+	// GeoNames 字段，旧的六列数据集与自定义数据集中为零值
+	GeoNameID    int    `json:"geonameid,omitempty" csv:"geonameid"`
+	Population   int    `json:"population,omitempty" csv:"population"`
+	FeatureClass string `json:"feature_class,omitempty" csv:"feature_class"`
+	FeatureCode  string `json:"feature_code,omitempty" csv:"feature_code"`
+	Timezone     string `json:"timezone,omitempty" csv:"timezone"`
+	Elevation    int    `json:"elevation,omitempty" csv:"elevation"` // 米
+	Admin1Code   string `json:"admin1_code,omitempty" csv:"admin1_code"`
+	Admin2Code   string `json:"admin2_code,omitempty" csv:"admin2_code"`
+
+	// Attributes 自定义数据集中未映射到上述字段的列（列名 -> 值）
+	Attributes map[string]string `json:"attributes,omitempty" csv:"-"`
+}
+
+// ColumnMapping 自定义 CSV 的列名映射：字段值为 CSV 表头中的列名。
+// Lat/Lon 必填，其余为空表示不读取；未映射的列进入 Location.Attributes。
+type ColumnMapping struct {
+	Lat    string
+	Lon    string
+	Name   string
+	Admin1 string
+	Admin2 string
+	CC     string
+
+	GeoNameID    string
+	Population   string
+	FeatureClass string
+	FeatureCode  string
+	Timezone     string
+	Elevation    string
+	Admin1Code   string
+	Admin2Code   string
+}
+
+// Neighbor 近邻查询结果：位置、内部索引与球面距离(km)
+type Neighbor struct {
+	Location Location `json:"location"`
+	Index    int      `json:"index"`
+	Distance float64  `json:"distance_km"`
+}
+
+// QueryResult 单点查询的详细结果
+type QueryResult struct {
+	Matched  bool     `json:"matched"` // false 表示无匹配（数据集为空或超出最大距离）
+	Location Location `json:"location"`
+	Index    int      `json:"index"`       // 在数据集中的索引，无结果时为 -1
+	Distance float64  `json:"distance_km"` // 查询点到匹配点的球面距离(km)
+	Bearing  float64  `json:"bearing"`     // 查询点指向匹配点的初始方位角，度，[0, 360)
+	Lat      float64  `json:"lat"`         // 匹配点纬度
+	Lon      float64  `json:"lon"`         // 匹配点经度
 }
 
 // GeoNamesRecord 原始GeoNames城市记录（只保留需要的字段）
@@ -24,11 +81,18 @@ type GeoNamesRecord struct {
 	ASCIIName        string    `csv:"asciiname"`
 	Latitude         float64   `csv:"latitude"`
 	Longitude        float64   `csv:"longitude"`
+	FeatureClass     string    `csv:"feature_class"`
+	FeatureCode      string    `csv:"feature_code"`
 	CountryCode      string    `csv:"country_code"`
 	Admin1Code       string    `csv:"admin1_code"`
 	Admin2Code       string    `csv:"admin2_code"`
 	Population       int       `csv:"population"`
+	Elevation        int       `csv:"elevation"` // 缺失时取 DEM，都缺失时为 0
+	Timezone         string    `csv:"timezone"`
 	ModificationDate time.Time `csv:"modification_date"`
+
+	// 原始的经纬度文本，生成数据集时原样写出（与 Python 版本逐字节一致）
+	latText, lonText string
 }
 
 // AdminRecord 行政区划编码映射
@@ -49,31 +113,131 @@ const (
 
 // Config 全局配置
 type Config struct {
-	Mode         QueryMode
-	Verbose      bool
-	DataDir      string
-	DownloadURLs URLs
-	MaxWorkers   int
-	CacheEnabled bool
-	DistanceMode DistanceMode
+	Mode             QueryMode
+	Verbose          bool
+	DataDir          string
+	DownloadURLs     URLs
+	MaxWorkers       int
+	CacheEnabled     bool
+	DistanceMode     DistanceMode
+	AutoDownload     bool    // 数据集缺失时是否从 DownloadURLs 下载原始数据
+	MaxDistance      float64 // 最大匹配距离(km)，<= 0 表示不限
+	TreeLayout       TreeLayout
+	Mmap             bool           // 从 DataDir 的快照启动时以 mmap 只读映射，而不是读入内存
+	DataFS           fs.FS          // 非空时从该文件系统读取数据集，不再使用 DataDir
+	DataFSName       string         // DataFS 中的数据集文件名，默认 rg_<Dataset.Name>.csv
+	Columns          *ColumnMapping // 非空时按列名映射读取 CSV，而不是要求固定表头
+	StrictLoad       bool           // 严格模式：CSV（以及生成数据集时的 GeoNames 文件）中任何一行无效即失败（*RowError 带行号）
+	RebuildThreshold int            // 增量修改累计到该值时后台重建KD树，0 为默认值，< 0 关闭自动重建
+	WatchInterval    time.Duration  // > 0 时定期检查 DataDir 中的文件并自动 Reload
+	OnReload         func(err error)
+	Dataset          Dataset // 要下载与生成的数据集，Name 为空时为 cities1000
 }
 
-// DistanceMode 距离模式
+// dataset 生效的数据集
+func (c *Config) dataset() Dataset {
+	if c.Dataset.Name != "" {
+		return c.Dataset
+	}
+	ds := Cities1000
+	if c.DownloadURLs.Cities1000 != "" {
+		ds.Files = []string{c.DownloadURLs.Cities1000}
+	}
+	return ds
+}
+
+// TreeLayout KD树存储布局
+type TreeLayout int
+
+const (
+	TreePointer TreeLayout = iota // 指针节点（默认）
+	TreeFlat                      // 扁平数组 + 叶子桶，适合大数据集
+)
+
+// DistanceMode 距离模式。只有 DistanceEuclideanDegrees 与 Python reverse_geocoder 的结果逐条一致：
+// 该库直接在原始纬经度上建 cKDTree，并不使用 ECEF。其余模式在高纬度与反子午线附近会选出不同（球面上更近）的位置。
 type DistanceMode int
 
 const (
 	DistanceHaversine        DistanceMode = iota // 球面距离(默认)
-	DistanceEuclideanDegrees                     // 直接纬经度欧氏，与Python reverse_geocoder一致
+	DistanceEuclideanDegrees                     // 直接纬经度欧氏，与Python reverse_geocoder一致（唯一的 parity 模式）
+	DistanceECEF                                 // WGS84 ECEF 三维直线距离(km)，不与 Python 版本一致
 )
 
 // URLs GeoNames数据下载URL集合
 type URLs struct {
 	BaseURL     string
-	Cities1000  string
+	Cities1000  string // Config.Dataset 未设置时使用的 cities 文件（兼容旧配置），设置后以 Dataset.Files 为准
 	Admin1Codes string
 	Admin2Codes string
 }
 
+// Dataset 要下载与生成的 GeoNames 数据集。不同 Name 的数据集生成到 DataDir 中不同的文件
+// （rg_<Name>.csv 及对应的快照、增量状态），可以共存。
+type Dataset struct {
+	Name  string   // 数据集名，用于文件命名
+	Files []string // BaseURL 下的原始 zip 文件，每个包含同名的 .txt（如 DE.zip -> DE.txt）
+
+	// 收录条件：feature class 为 P，且人口超过 MinPopulation（<= 0 不限）或 feature code 属于 Seats。
+	// Filter 为 false 时全量文件视为已按该条件筛选（cities 文件），只在应用每日增量时使用。
+	MinPopulation int
+	Seats         []string
+	Countries     []string // 非空时只收录这些国家（增量文件覆盖全世界）
+	Filter        bool
+}
+
+// GeoNames 提供的数据集，收录条件与 GeoNames readme 的说明一致
+var (
+	Cities500    = Dataset{Name: "cities500", Files: []string{"cities500.zip"}, MinPopulation: 500, Seats: []string{"PPLC", "PPLA", "PPLA2", "PPLA3", "PPLA4"}}
+	Cities1000   = Dataset{Name: "cities1000", Files: []string{"cities1000.zip"}, MinPopulation: 1000, Seats: []string{"PPLC", "PPLA", "PPLA2", "PPLA3"}}
+	Cities5000   = Dataset{Name: "cities5000", Files: []string{"cities5000.zip"}, MinPopulation: 5000, Seats: []string{"PPLC", "PPLA"}}
+	Cities15000  = Dataset{Name: "cities15000", Files: []string{"cities15000.zip"}, MinPopulation: 15000, Seats: []string{"PPLC"}}
+	AllCountries = Dataset{Name: "allCountries", Files: []string{"allCountries.zip"}, Filter: true} // 所有居民点
+)
+
+// CountryDataset 由若干国家文件（XX.zip）组成的数据集，收录其中所有居民点
+func CountryDataset(codes ...string) Dataset {
+	ds := Dataset{Name: "countries", Filter: true}
+	for _, cc := range codes {
+		cc = strings.ToUpper(cc)
+		ds.Name += "-" + cc
+		ds.Files = append(ds.Files, cc+".zip")
+		ds.Countries = append(ds.Countries, cc)
+	}
+	return ds
+}
+
+// ParseDataset 解析数据集名：cities500 / cities1000 / cities5000 / cities15000 / allCountries，
+// 或 countries:DE,FR 表示国家文件
+func ParseDataset(s string) (Dataset, error) {
+	if codes, ok := strings.CutPrefix(s, "countries:"); ok {
+		list := strings.Split(codes, ",")
+		for _, cc := range list {
+			if len(cc) != 2 {
+				return Dataset{}, fmt.Errorf("invalid country code %q", cc)
+			}
+		}
+		return CountryDataset(list...), nil
+	}
+	for _, ds := range []Dataset{Cities500, Cities1000, Cities5000, Cities15000, AllCountries} {
+		if strings.EqualFold(s, ds.Name) {
+			return ds, nil
+		}
+	}
+	return Dataset{}, fmt.Errorf("unknown dataset %q", s)
+}
+
+// keep 记录是否满足数据集的收录条件
+func (ds Dataset) keep(g GeoNamesRecord) bool {
+	if g.FeatureClass != "P" {
+		return false
+	}
+	if len(ds.Countries) > 0 && !slices.Contains(ds.Countries, g.CountryCode) {
+		return false
+	}
+	return ds.MinPopulation <= 0 || g.Population > ds.MinPopulation || slices.Contains(ds.Seats, g.FeatureCode)
+}
+
 // 默认下载URL
 var DefaultURLs = URLs{
 	BaseURL:     "http://download.geonames.org/export/dump/",
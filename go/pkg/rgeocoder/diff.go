@@ -0,0 +1,319 @@
+package rgeocoder
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const diffDateLayout = "2006-01-02"
+
+// ErrDiffApplied 该日期的增量已经应用过（或早于最近一次应用的增量）
+var ErrDiffApplied = errors.New("diff already applied")
+
+// DiffRecord 一次增量应用的记录
+type DiffRecord struct {
+	Date      string    `json:"date"` // 增量文件的日期 YYYY-MM-DD
+	AppliedAt time.Time `json:"applied_at"`
+	Updated   int       `json:"updated"` // 已在数据集中、修改后仍满足收录条件的行
+	Added     int       `json:"added"`   // 新收录的行
+	Removed   int       `json:"removed"` // 被删除或修改后不再满足收录条件的行
+	Skipped   int       `json:"skipped"` // 与数据集无关的修改（不在数据集中且不满足收录条件）
+	Rows      int       `json:"rows"`    // 应用后的行数
+}
+
+// DiffState 数据集的增量状态，保存在数据集旁的 .diffs.json 中
+type DiffState struct {
+	// LastApplied 最近一次应用的增量日期；全量生成后为源数据中最新的修改日期
+	LastApplied string       `json:"last_applied"`
+	History     []DiffRecord `json:"history,omitempty"`
+}
+
+// DiffFiles 某一天的 GeoNames 增量文件名
+func DiffFiles(date time.Time) (modifications, deletes string) {
+	day := date.Format(diffDateLayout)
+	return "modifications-" + day + ".txt", "deletes-" + day + ".txt"
+}
+
+// DownloadDiff 下载某一天的 modifications / deletes 文件到 DataDir
+func (d *Downloader) DownloadDiff(date time.Time) error {
+	if err := EnsureDir(d.config.DataDir); err != nil {
+		return err
+	}
+	mods, dels := DiffFiles(date)
+	for _, name := range []string{mods, dels} {
+		if err := d.DownloadFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDiffState 读取 dataDir 中数据集 ds 的增量状态
+func LoadDiffState(dataDir string, ds Dataset) (*DiffState, error) {
+	path := diffStatePath(dataDir, ds)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state DiffState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// ApplyDiff 将 DataDir 中某一天的 modifications / deletes 文件按 geonameid 应用到已生成的 Config.Dataset：
+// 修改后满足数据集收录条件的记录重新关联 admin 名称并替换或追加，不再满足的移除，deletes 中的记录移除。
+// 增量必须按日期依次应用；同一天重复应用返回 ErrDiffApplied。
+// 数据集在原位更新，运行中的 RGeocoder 需要 Reload（或 WithWatch）才能看到变化。
+func (p *DataProcessor) ApplyDiff(date time.Time) (DiffRecord, error) {
+	dir := p.config.DataDir
+	urls := p.config.DownloadURLs
+	ds := p.config.dataset()
+	rec := DiffRecord{Date: date.Format(diffDateLayout)}
+
+	state, err := LoadDiffState(dir, ds)
+	if err != nil {
+		return rec, fmt.Errorf("dataset has no diff state, regenerate it with ProcessGeoNamesData: %w", err)
+	}
+	if state.LastApplied != "" {
+		last, err := time.Parse(diffDateLayout, state.LastApplied)
+		if err != nil {
+			return rec, fmt.Errorf("diff state: %w", err)
+		}
+		next := last.AddDate(0, 0, 1).Format(diffDateLayout)
+		switch {
+		case rec.Date < next:
+			return rec, fmt.Errorf("%s: %w (last applied %s)", rec.Date, ErrDiffApplied, state.LastApplied)
+		case rec.Date > next:
+			return rec, fmt.Errorf("diffs must be applied in order: next is %s, got %s", next, rec.Date)
+		}
+	}
+
+	rows, ids, err := readKeyedDataset(dir, ds)
+	if err != nil {
+		return rec, err
+	}
+	pos := make(map[int]int, len(ids))
+	for i, id := range ids {
+		pos[id] = i
+	}
+	removed := make(map[int]bool)
+	added := make(map[int]bool) // 本次新增的记录，之后又被移除时撤销 Added 而不计入 Removed
+	drop := func(id int) {
+		if removed[id] {
+			return
+		}
+		removed[id] = true
+		if added[id] {
+			rec.Added--
+		} else {
+			rec.Removed++
+		}
+	}
+
+	modsName, delsName := DiffFiles(date)
+	var admin1, admin2 map[string]AdminRecord
+	err = scanDiffFile(filepath.Join(dir, modsName), func(fields []string) error {
+		g, err := ParseGeoNamesRecord(fields)
+		if err != nil {
+			return err
+		}
+		i, ok := pos[g.GeoNameID]
+		if !ds.keep(g) {
+			if ok {
+				drop(g.GeoNameID)
+			} else {
+				rec.Skipped++
+			}
+			return nil
+		}
+		// admin 文件只在确实有行需要关联时读取
+		if admin1 == nil {
+			if admin1, err = loadAdminFile(filepath.Join(dir, urls.Admin1Codes)); err != nil {
+				return err
+			}
+			if admin2, err = loadAdminFile(filepath.Join(dir, urls.Admin2Codes)); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			pos[g.GeoNameID] = len(rows)
+			rows = append(rows, g.toRow(admin1, admin2))
+			ids = append(ids, g.GeoNameID)
+			added[g.GeoNameID] = true
+			rec.Added++
+			return nil
+		}
+		rows[i] = g.toRow(admin1, admin2)
+		// 同一文件中重复出现：以最后一次为准
+		wasRemoved := removed[g.GeoNameID]
+		delete(removed, g.GeoNameID)
+		switch {
+		case added[g.GeoNameID]:
+			if wasRemoved {
+				rec.Added++
+			}
+		case wasRemoved:
+			rec.Removed--
+			rec.Updated++
+		default:
+			rec.Updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return rec, fmt.Errorf("%s: %w", modsName, err)
+	}
+	// 同一天既修改又删除的记录以删除为准
+	err = scanDiffFile(filepath.Join(dir, delsName), func(fields []string) error {
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("geonameid: %w", err)
+		}
+		if _, ok := pos[id]; ok {
+			drop(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return rec, fmt.Errorf("%s: %w", delsName, err)
+	}
+
+	if len(removed) > 0 {
+		n := 0
+		for i, id := range ids {
+			if !removed[id] {
+				rows[n], ids[n] = rows[i], id
+				n++
+			}
+		}
+		rows, ids = rows[:n], ids[:n]
+	}
+	rec.Rows = len(rows)
+
+	// 先写数据再写状态：中途失败时重新应用同一天的增量结果相同
+	err = writeFileAtomic(datasetPath(dir, ds), func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(expectedHeader); err != nil {
+			return err
+		}
+		return cw.WriteAll(rows)
+	})
+	if err != nil {
+		return rec, err
+	}
+	rec.AppliedAt = time.Now().UTC()
+	state.LastApplied = rec.Date
+	state.History = append(state.History, rec)
+	if err := saveDiffState(dir, ds, state); err != nil {
+		return rec, err
+	}
+	if p.config.Verbose {
+		fmt.Printf("applied diff %s: %d updated, %d added, %d removed, %d rows\n", rec.Date, rec.Updated, rec.Added, rec.Removed, rec.Rows)
+	}
+	return rec, nil
+}
+
+// ApplyPendingDiffs 从最近一次应用的增量的下一天起，逐日下载并应用到 until（含），
+// 返回已应用的记录。某一天失败时停止，之前已应用的保留。
+func (p *DataProcessor) ApplyPendingDiffs(d *Downloader, until time.Time) ([]DiffRecord, error) {
+	state, err := LoadDiffState(p.config.DataDir, p.config.dataset())
+	if err != nil {
+		return nil, fmt.Errorf("dataset has no diff state, regenerate it with ProcessGeoNamesData: %w", err)
+	}
+	if state.LastApplied == "" {
+		return nil, errors.New("diff state has no base date, regenerate the dataset with ProcessGeoNamesData")
+	}
+	last, err := time.Parse(diffDateLayout, state.LastApplied)
+	if err != nil {
+		return nil, fmt.Errorf("diff state: %w", err)
+	}
+	until = time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, time.UTC)
+	var applied []DiffRecord
+	for day := last.AddDate(0, 0, 1); !day.After(until); day = day.AddDate(0, 0, 1) {
+		if err := d.DownloadDiff(day); err != nil {
+			return applied, err
+		}
+		rec, err := p.ApplyDiff(day)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, rec)
+	}
+	return applied, nil
+}
+
+// readKeyedDataset 读取处理后的数据集及其逐行的 geonameid
+func readKeyedDataset(dir string, ds Dataset) ([][]string, []int, error) {
+	path := datasetPath(dir, ds)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rows) == 0 || !slices.Equal(rows[0], expectedHeader) {
+		return nil, nil, fmt.Errorf("%s has no geonameid column, regenerate it with ProcessGeoNamesData", path)
+	}
+	rows = rows[1:]
+	ids := make([]int, len(rows))
+	for i, row := range rows {
+		if len(row) != len(expectedHeader) {
+			return nil, nil, fmt.Errorf("%s line %d: %d fields, want %d", path, i+2, len(row), len(expectedHeader))
+		}
+		if ids[i], err = strconv.Atoi(row[colGeoNameID]); err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: geonameid: %w", path, i+2, err)
+		}
+	}
+	return rows, ids, nil
+}
+
+// scanDiffFile 逐行读取制表符分隔的增量文件
+func scanDiffFile(path string, fn func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if text := scanner.Text(); text != "" {
+			if err := fn(strings.Split(text, "\t")); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func saveDiffState(dir string, ds Dataset, state *DiffState) error {
+	return writeFileAtomic(diffStatePath(dir, ds), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(state)
+	})
+}
+
+// diffStatePath 数据集的增量状态
+func diffStatePath(dataDir string, ds Dataset) string {
+	return strings.TrimSuffix(datasetPath(dataDir, ds), ".csv") + ".diffs.json"
+}
@@ -1,20 +1,283 @@
 package rgeocoder
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Downloader 下载GeoNames数据（占位）
+const (
+	defaultDownloadRetries = 3
+	defaultDownloadBackoff = 500 * time.Millisecond
+	// defaultDownloadTimeout 单次请求（含读取响应体）的上限；超时后按重试规则从 .part 续传
+	defaultDownloadTimeout = 30 * time.Minute
+	partSuffix             = ".part"
+	etagSuffix             = ".etag"
+)
+
+// errNotModified 条件请求返回 304
+var errNotModified = errors.New("not modified")
+
+// Downloader 从 GeoNames（或任意镜像）下载原始数据到 DataDir
 type Downloader struct {
 	config *Config
+
+	// Client 发起请求的 HTTP 客户端，默认是超时为 30 分钟的客户端
+	Client *http.Client
+	// MaxRetries 单个文件失败后的最大重试次数
+	MaxRetries int
+	// Backoff 首次重试前的等待时间，之后每次翻倍
+	Backoff time.Duration
+}
+
+func NewDownloader(cfg *Config) *Downloader {
+	return &Downloader{
+		config:     cfg,
+		Client:     &http.Client{Timeout: defaultDownloadTimeout},
+		MaxRetries: defaultDownloadRetries,
+		Backoff:    defaultDownloadBackoff,
+	}
 }
 
-func NewDownloader(cfg *Config) *Downloader { return &Downloader{config: cfg} }
+// RequiredFiles 返回生成 Config.Dataset 所需的原始文件名
+func (d *Downloader) RequiredFiles() []string {
+	u := d.config.DownloadURLs
+	return append(slices.Clone(d.config.dataset().Files), u.Admin1Codes, u.Admin2Codes)
+}
 
+// DownloadRequired 下载数据集的 zip 文件与 admin1CodesASCII.txt / admin2Codes.txt，已存在的文件跳过
 func (d *Downloader) DownloadRequired() error {
-	// TODO: 实现下载逻辑
+	if err := EnsureDir(d.config.DataDir); err != nil {
+		return err
+	}
+	for _, name := range d.RequiredFiles() {
+		if err := d.DownloadFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownloadFile 下载 BaseURL/name 到 DataDir/name。
+// 先写入 name.part，完成后再原子重命名；中断后再次调用会用 Range 请求续传。
+// 响应的 Last-Modified 记为文件的修改时间，ETag 保存在 name.etag 中，供 DownloadIfModified 使用。
+func (d *Downloader) DownloadFile(name string) error {
+	return d.download(context.Background(), name, nil)
+}
+
+// DownloadIfModified 同 DownloadFile，但 ref（通常是上次下载的同名文件）存在时发送条件请求：
+// If-None-Match 取自 ref.etag，If-Modified-Since 取 ref 的修改时间。服务端返回 304 时不下载，返回 false。
+// ctx 取消时中止进行中的请求与重试等待。
+func (d *Downloader) DownloadIfModified(ctx context.Context, name, ref string) (bool, error) {
+	cond := make(http.Header)
+	if fi, err := os.Stat(ref); err == nil && fi.Size() > 0 {
+		cond.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+		if etag, err := os.ReadFile(ref + etagSuffix); err == nil {
+			cond.Set("If-None-Match", strings.TrimSpace(string(etag)))
+		}
+	}
+	err := d.download(ctx, name, cond)
+	if errors.Is(err, errNotModified) {
+		if d.config.Verbose {
+			fmt.Println("not modified:", name)
+		}
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// download 带重试地下载 name，cond 为首次请求（非续传）附加的条件请求头
+func (d *Downloader) download(ctx context.Context, name string, cond http.Header) error {
+	dest := filepath.Join(d.config.DataDir, name)
+	if fi, err := os.Stat(dest); err == nil && fi.Size() > 0 {
+		if d.config.Verbose {
+			fmt.Println("already downloaded:", dest)
+		}
+		return nil
+	}
+	url := joinURL(d.config.DownloadURLs.BaseURL, name)
+	backoff := d.Backoff
+	var lastErr error
+	for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if d.config.Verbose {
+				fmt.Printf("retrying %s in %s (attempt %d): %v\n", url, backoff, attempt, lastErr)
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("download %s: %w", url, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		err := d.fetch(ctx, url, dest, cond)
+		if err == nil || errors.Is(err, errNotModified) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("download %s: %w", url, ctx.Err())
+		}
+		lastErr = err
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			break
+		}
+	}
+	return fmt.Errorf("download %s: %w", url, lastErr)
+}
+
+// fetch 执行一次下载尝试，断点续传到 dest.part
+func (d *Downloader) fetch(ctx context.Context, url, dest string, cond http.Header) error {
+	part := dest + partSuffix
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return &permanentError{err}
+	}
+	defer func() {
+		// 没有收到任何数据时不留下空的 .part
+		if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+			os.Remove(part)
+		}
+		f.Close()
+	}()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	resp, err := d.get(ctx, url, offset, cond)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && (resp.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
+		resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) != offset) {
+		// 本地 .part 与远端不一致，或返回的范围无法接续：丢弃后不带 Range 从头下载
+		resp.Body.Close()
+		if d.config.Verbose {
+			fmt.Printf("cannot resume %s at %d (%s), restarting\n", url, offset, resp.Status)
+		}
+		if err := f.Truncate(0); err != nil {
+			return &permanentError{err}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return &permanentError{err}
+		}
+		offset = 0
+		if resp, err = d.get(ctx, url, offset, cond); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && offset == 0 && len(cond) > 0:
+		return errNotModified
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		// 续传
+	case resp.StatusCode == http.StatusOK:
+		// 服务端不支持 Range 或首次下载，从头写
+		if err := f.Truncate(0); err != nil {
+			return &permanentError{err}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return &permanentError{err}
+		}
+		offset = 0
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	default:
+		return &permanentError{fmt.Errorf("unexpected status: %s", resp.Status)}
+	}
+
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("short body: got %d of %d bytes", n, resp.ContentLength)
+	}
+	if err := f.Sync(); err != nil {
+		return &permanentError{err}
+	}
+	if err := f.Close(); err != nil {
+		return &permanentError{err}
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return &permanentError{err}
+	}
+	saveValidators(dest, resp)
 	if d.config.Verbose {
-		fmt.Println("DownloadRequired placeholder")
+		fmt.Printf("downloaded %s (%d bytes)\n", dest, offset+n)
 	}
 	return nil
 }
+
+// get 发起一次 GET：offset > 0 时请求 Range 续传，否则附加条件请求头 cond
+func (d *Downloader) get(ctx context.Context, url string, offset int64, cond http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		for k, v := range cond {
+			req.Header[k] = v
+		}
+	}
+	if d.config.Verbose {
+		fmt.Printf("downloading %s (offset %d)\n", url, offset)
+	}
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// saveValidators 记录响应的 Last-Modified 与 ETag，供下次条件请求使用；失败只会让下次多下载一次，忽略
+func saveValidators(dest string, resp *http.Response) {
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		_ = os.Chtimes(dest, lm, lm)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		_ = os.WriteFile(dest+etagSuffix, []byte(etag), 0o644)
+	} else {
+		_ = os.Remove(dest + etagSuffix)
+	}
+}
+
+// permanentError 表示不值得重试的错误（4xx、本地IO等）
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// contentRangeStart 解析 "bytes start-end/total" 中的 start，失败返回 -1
+func contentRangeStart(resp *http.Response) int64 {
+	cr := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	i := strings.IndexByte(cr, '-')
+	if i <= 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(cr[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+func joinURL(base, name string) string {
+	if base == "" {
+		return name
+	}
+	return strings.TrimRight(base, "/") + "/" + name
+}
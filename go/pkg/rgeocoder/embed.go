@@ -0,0 +1,12 @@
+//go:build rgeocoder_embed
+
+package rgeocoder
+
+import _ "embed"
+
+// embeddedData 以 rgeocoder_embed 构建时编入的快照；文件不存在时编译失败，先运行 go generate 生成
+//
+//go:embed embedded/rg_cities1000.snap.gz
+var embeddedData []byte
+
+func embeddedSnapshot() []byte { return embeddedData }
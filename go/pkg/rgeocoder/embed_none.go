@@ -0,0 +1,5 @@
+//go:build !rgeocoder_embed
+
+package rgeocoder
+
+func embeddedSnapshot() []byte { return nil }
@@ -0,0 +1,37 @@
+package rgeocoder
+
+import "fmt"
+
+// 编入二进制的 gzip 快照为 embedded/rg_cities1000.snap.gz，以 rgeocoder_embed 构建前先生成
+// （在 go/ 目录下；go/data 中没有数据集时会从 GeoNames 下载）：
+//
+//	go generate ./pkg/rgeocoder
+//	go build -tags rgeocoder_embed ./cmd/rgeocoder
+//
+//go:generate go run ../../cmd/rgeocoder -data-dir ../../data -save-snapshot embedded/rg_cities1000.snap.gz
+
+// HasEmbeddedDataset 报告当前二进制是否编入了默认数据集
+func HasEmbeddedDataset() bool { return len(embeddedSnapshot()) > 0 }
+
+// loadEmbedded DataDir 中没有数据集时使用编入的快照，不读写磁盘。
+// 距离模式与快照不一致时按当前配置重新建树。
+func loadEmbedded(cfg *Config) (*RGeocoder, bool) {
+	data := embeddedSnapshot()
+	if data == nil {
+		return nil, false
+	}
+	snap, err := parseSnapshot(data)
+	if err != nil {
+		if cfg.Verbose {
+			fmt.Println("ignoring embedded dataset:", err)
+		}
+		return nil, false
+	}
+	if cfg.Verbose {
+		fmt.Printf("using embedded dataset (%d locations)\n", len(snap.locs))
+	}
+	if snap.mode != cfg.DistanceMode {
+		return newGeocoder(cfg, snap.coords, locationSlice(snap.locs), buildTree(snap.coords, cfg)), true
+	}
+	return snap.geocoder(cfg), true
+}
@@ -1,27 +1,91 @@
 package rgeocoder
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // KDTreeInterface 允许不同实现（单线程 / 多线程）
 type KDTreeInterface interface {
 	Query(coords []Coordinate, k int) ([]float64, []int, error)
+	QueryContext(ctx context.Context, coords []Coordinate, k int) ([]float64, []int, error)
+}
+
+// RadiusQuerier 可选能力：按球面半径(km)检索，结果按距离升序，limit > 0 时截断
+type RadiusQuerier interface {
+	QueryRadius(c Coordinate, radiusKm float64, limit int) ([]float64, []int)
+}
+
+// RangeQuerier 可选能力：经纬度矩形检索，返回升序索引；minLon > maxLon 表示跨越反子午线
+type RangeQuerier interface {
+	QueryBBox(minLat, minLon, maxLat, maxLon float64) []int
+}
+
+// baseTree 内置单线程实现同时具备的能力
+type baseTree interface {
+	KDTreeInterface
+	RadiusQuerier
+	RangeQuerier
 }
 
 // RGeocoder 主结构体
 type RGeocoder struct {
-	mode      QueryMode
-	verbose   bool
-	tree      KDTreeInterface
-	locations []Location
-	mu        sync.RWMutex
-	config    *Config
+	mode    QueryMode
+	verbose bool
+	cur     atomic.Pointer[loadedData] // 当前数据，Reload 时原子替换，查询不加锁
+
+	reloadMu  sync.Mutex // 串行化数据替换与 Close，保护 closed
+	closed    bool
+	watchStop context.CancelFunc
+}
+
+// ErrClosed RGeocoder 已关闭
+var ErrClosed = errors.New("rgeocoder: closed")
+
+// loadedData 一次加载得到的索引、加载报告与配置，Reload 时整体替换。
+// 引用计数：作为当前数据时持有 1 个，每个进行中的查询各持有 1 个，归零时关闭索引（如解除 mmap 映射）。
+type loadedData struct {
+	idx      *Index[Location]
+	report   *LoadReport
+	config   *Config
+	source   snapshotSource // 数据来自的数据集文件，SaveSnapshot 记录在快照中
+	modified atomic.Bool    // 加载后经过 Add/Remove/Update，不再与 source 一致
+	refs     atomic.Int64
+}
+
+func newLoadedData(idx *Index[Location], report *LoadReport, cfg *Config) *loadedData {
+	d := &loadedData{idx: idx, report: report, config: cfg}
+	d.refs.Store(1)
+	return d
+}
+
+// acquire 增加引用；已归零（索引已关闭或正在关闭）时返回 false
+func (d *loadedData) acquire() bool {
+	for {
+		n := d.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if d.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release 释放引用，最后一个持有者关闭索引
+func (d *loadedData) release() {
+	if d.refs.Add(-1) == 0 {
+		d.idx.Close()
+	}
 }
 
 // Option 函数式配置
@@ -42,6 +106,45 @@ func WithMaxWorkers(n int) Option { return func(c *Config) { c.MaxWorkers = n }
 // WithDistanceMode 设置距离模式
 func WithDistanceMode(m DistanceMode) Option { return func(c *Config) { c.DistanceMode = m } }
 
+// WithDataset 选择要下载与生成的数据集（Cities500、Cities15000、AllCountries、CountryDataset("DE") 等），默认 Cities1000
+func WithDataset(ds Dataset) Option { return func(c *Config) { c.Dataset = ds } }
+
+// WithDownloadURLs 设置GeoNames下载地址（可指向内部镜像）
+func WithDownloadURLs(u URLs) Option { return func(c *Config) { c.DownloadURLs = u } }
+
+// WithAutoDownload 设置数据集缺失时是否自动下载
+func WithAutoDownload(v bool) Option { return func(c *Config) { c.AutoDownload = v } }
+
+// WithMaxDistance 设置最大匹配距离(km)，超出视为无匹配；<= 0 表示不限
+func WithMaxDistance(km float64) Option { return func(c *Config) { c.MaxDistance = km } }
+
+// WithTreeLayout 选择KD树存储布局
+func WithTreeLayout(l TreeLayout) Option { return func(c *Config) { c.TreeLayout = l } }
+
+// WithDataFS 从 fs.FS 读取数据集（如 embed.FS），name 为空时使用 rg_<Dataset.Name>.csv；压缩格式按魔数识别
+func WithDataFS(fsys fs.FS, name string) Option {
+	return func(c *Config) { c.DataFS, c.DataFSName = fsys, name }
+}
+
+// WithColumnMapping 按列名映射读取自定义 CSV，多余的列保存在 Location.Attributes
+func WithColumnMapping(m ColumnMapping) Option { return func(c *Config) { c.Columns = &m } }
+
+// WithStrictLoad 严格加载：任何无效行（坐标无法解析、越界、NaN、重复、列数不符）都使加载失败
+func WithStrictLoad(v bool) Option { return func(c *Config) { c.StrictLoad = v } }
+
+// WithRebuildThreshold 增量修改（新增、更新、删除）累计到 n 条时在后台重建KD树；
+// 0 使用默认值 1024，< 0 表示只在调用 Rebuild 时重建
+func WithRebuildThreshold(n int) Option { return func(c *Config) { c.RebuildThreshold = n } }
+
+// WithWatch 每隔 interval 检查 DataDir 中的数据集与快照，文件变化后自动 Reload；<= 0 表示不监视
+func WithWatch(interval time.Duration) Option { return func(c *Config) { c.WatchInterval = interval } }
+
+// WithReloadHook 每次 Reload（手动或由监视触发）结束后回调，成功时 err 为 nil
+func WithReloadHook(fn func(err error)) Option { return func(c *Config) { c.OnReload = fn } }
+
+// WithMmap 设置从快照启动时是否使用 mmap（多进程共享页缓存）
+func WithMmap(v bool) Option { return func(c *Config) { c.Mmap = v } }
+
 // applyOptions 应用默认与用户选项
 func applyOptions(opts []Option) *Config {
 	cfg := &Config{
@@ -52,59 +155,86 @@ func applyOptions(opts []Option) *Config {
 		MaxWorkers:   0,
 		CacheEnabled: true,
 		DistanceMode: DistanceHaversine,
+		AutoDownload: true,
 	}
 	for _, o := range opts {
 		o(cfg)
 	}
 	if cfg.MaxWorkers <= 0 {
-		cfg.MaxWorkers = 4
+		cfg.MaxWorkers = runtime.GOMAXPROCS(0)
 	}
 	return cfg
 }
 
-// NewRGeocoder 创建实例（自动加载或下载数据的逻辑待实现）
+// NewRGeocoder 创建实例：优先加载 DataDir 中不旧于 CSV 的快照；
+// 数据集缺失时先使用编入的数据集（rgeocoder_embed 构建），否则自动下载并生成
 func NewRGeocoder(opts ...Option) (*RGeocoder, error) {
 	cfg := applyOptions(opts)
-	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+	watch := cfg.WatchInterval > 0 && cfg.DataFS == nil
+	var seen string
+	if watch {
+		// 加载前记录文件状态，加载期间发生的变化也会触发一次 Reload
+		seen = statFiles(watchPaths(cfg))
+	}
+	rg, err := loadDataset(cfg)
+	if err != nil {
 		return nil, err
 	}
+	if watch {
+		rg.startWatch(cfg.WatchInterval, seen)
+	}
+	return rg, nil
+}
+
+// loadDataset 按配置加载数据集（DataFS、快照、编入数据、CSV，必要时下载生成）
+func loadDataset(cfg *Config) (*RGeocoder, error) {
+	if cfg.DataFS != nil {
+		return newRGeocoderFromFS(cfg)
+	}
 
-	// 加载数据 (允许空数据集，而不是 panic，以便测试和首次运行)
-	citiesFile := filepath.Join(cfg.DataDir, "rg_cities1000.csv")
+	// 加载数据：生成或读取失败时返回错误，而不是静默使用空数据集
+	citiesFile := datasetPath(cfg.DataDir, cfg.dataset())
 	if cfg.Verbose {
 		fmt.Printf("checking data file: %s\n", citiesFile)
 	}
+	if rg, ok := loadFreshSnapshot(cfg, citiesFile); ok {
+		return rg, nil
+	}
 
+	loader := NewDataLoader(cfg)
 	var coords []Coordinate
 	var locs []Location
 	if _, errStat := os.Stat(citiesFile); errors.Is(errStat, os.ErrNotExist) {
+		// 编入的是 cities1000，其他数据集照常生成
+		if cfg.dataset().Name == Cities1000.Name {
+			if rg, ok := loadEmbedded(cfg); ok {
+				return rg, nil
+			}
+		}
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return nil, err
+		}
 		if cfg.Verbose {
-			fmt.Println("dataset not found, starting with empty dataset (place file at:", citiesFile, ")")
+			fmt.Println("dataset not found, building from GeoNames data:", citiesFile)
+		}
+		c, l, err := loader.ExtractAndProcess()
+		if err != nil {
+			return nil, fmt.Errorf("build dataset: %w", err)
 		}
+		coords, locs = c, l
 	} else {
-		loader := NewDataLoader(cfg)
 		c, l, err := loader.LoadFromFile(citiesFile)
 		if err != nil {
-			if cfg.Verbose {
-				fmt.Println("failed to load dataset:", err)
-			}
-		} else {
-			coords, locs = c, l
-			if cfg.Verbose {
-				fmt.Printf("loaded %d locations\n", len(locs))
-			}
+			return nil, fmt.Errorf("load %s: %w", citiesFile, err)
 		}
+		coords, locs = c, l
 	}
-
-	// 构建KD树，传入距离模式
-	var tree KDTreeInterface
-	if cfg.Mode == SingleThreaded {
-		tree = NewKDTree(coords, cfg.DistanceMode)
-	} else {
-		tree = NewKDTreeMP(coords, cfg.MaxWorkers, cfg.DistanceMode)
+	if cfg.Verbose {
+		fmt.Printf("loaded %d locations\n", len(locs))
 	}
 
-	return &RGeocoder{mode: cfg.Mode, verbose: cfg.Verbose, tree: tree, locations: locs, config: cfg}, nil
+	// 构建KD树，传入距离模式
+	return newLoadedGeocoder(cfg, loader, coords, locs), nil
 }
 
 // NewRGeocoderWithStream 使用内存流初始化
@@ -115,52 +245,319 @@ func NewRGeocoderWithStream(stream io.Reader, opts ...Option) (*RGeocoder, error
 	if err != nil {
 		return nil, err
 	}
-	var tree KDTreeInterface
-	if cfg.Mode == SingleThreaded {
-		tree = NewKDTree(coords, cfg.DistanceMode)
+	return newLoadedGeocoder(cfg, loader, coords, locs), nil
+}
+
+// newRGeocoderFromFS 从 Config.DataFS 加载，失败直接返回错误
+func newRGeocoderFromFS(cfg *Config) (*RGeocoder, error) {
+	name := cfg.DataFSName
+	if name == "" {
+		name = filepath.Base(datasetPath("", cfg.dataset()))
+	}
+	loader := NewDataLoader(cfg)
+	coords, locs, err := loader.LoadFromFS(cfg.DataFS, name)
+	if err != nil {
+		return nil, fmt.Errorf("load %s from fs: %w", name, err)
+	}
+	return newLoadedGeocoder(cfg, loader, coords, locs), nil
+}
+
+// newLoadedGeocoder 用 CSV 加载结果建树，并保留加载报告
+func newLoadedGeocoder(cfg *Config, dl *DataLoader, coords []Coordinate, locs []Location) *RGeocoder {
+	rg := newGeocoder(cfg, coords, locationSlice(locs), buildTree(coords, cfg))
+	d := rg.cur.Load()
+	d.report, d.source = dl.Report(), dl.source
+	return rg
+}
+
+// LoadReport 从 CSV 加载时的统计（拒绝行数与样本）；从快照或编入数据集加载时为 nil
+func (rg *RGeocoder) LoadReport() *LoadReport { return rg.cur.Load().report }
+
+// currentConfig 当前数据使用的配置（只读）
+func (rg *RGeocoder) currentConfig() *Config { return rg.cur.Load().config }
+
+func newGeocoder(cfg *Config, coords []Coordinate, locs locationStore, tree KDTreeInterface) *RGeocoder {
+	rg := &RGeocoder{mode: cfg.Mode, verbose: cfg.Verbose}
+	rg.cur.Store(newLoadedData(newIndex(cfg, coords, locs, tree), nil, cfg))
+	return rg
+}
+
+// buildTree 按查询模式与距离模式选择KD树实现
+func buildTree(coords []Coordinate, cfg *Config) KDTreeInterface {
+	var base baseTree
+	if cfg.TreeLayout == TreeFlat {
+		base = NewFlatKDTree(coords, cfg.DistanceMode)
 	} else {
-		tree = NewKDTreeMP(coords, cfg.MaxWorkers, cfg.DistanceMode)
+		base = newBaseTree(coords, cfg.DistanceMode)
 	}
-	return &RGeocoder{mode: cfg.Mode, verbose: cfg.Verbose, tree: tree, locations: locs, config: cfg}, nil
+	return wrapTree(base, cfg)
 }
 
-// Query 批量查询
-func (rg *RGeocoder) Query(coordinates []Coordinate) ([]Location, error) {
-	if len(coordinates) == 0 {
-		return nil, fmt.Errorf("no coordinates provided")
+// wrapTree 多线程模式下用worker池包装单线程实现
+func wrapTree(base baseTree, cfg *Config) KDTreeInterface {
+	if cfg.Mode == SingleThreaded {
+		return base
+	}
+	return newKDTreeMP(base, cfg.MaxWorkers)
+}
+
+// newBaseTree 单线程实现：ECEF 用三维树，其余用经纬度二维树
+func newBaseTree(coords []Coordinate, mode DistanceMode) baseTree {
+	if mode == DistanceECEF {
+		return NewECEFTree(coords)
+	}
+	return NewKDTree(coords, mode)
+}
+
+// ErrNoMatch 没有可用的匹配（数据集为空，或最近点超出 WithMaxDistance 设定的距离）
+var ErrNoMatch = errors.New("no match")
+
+// Index 取当前的 Index[Location] 并持有一个引用，用完后必须调用 release。
+// 持有期间即使发生 Reload 或 Close，该索引也不会被关闭；RGeocoder 已关闭时返回 ErrClosed。
+func (rg *RGeocoder) Index() (ix *Index[Location], release func(), err error) {
+	return rg.index()
+}
+
+// Len 当前数据的位置数量，已关闭时为 0
+func (rg *RGeocoder) Len() int {
+	ix, release, err := rg.index()
+	if err != nil {
+		return 0
+	}
+	defer release()
+	return ix.Len()
+}
+
+// index 取当前数据的引用；Reload 替换后旧数据在所有持有者释放后才关闭
+func (rg *RGeocoder) index() (*Index[Location], func(), error) {
+	d, err := rg.acquire()
+	if err != nil {
+		return nil, nil, err
 	}
-	// 验证
-	for _, c := range coordinates {
-		if c.Lat < -90 || c.Lat > 90 || c.Lon < -180 || c.Lon > 180 {
-			return nil, fmt.Errorf("invalid coordinate: %+v", c)
+	return d.idx, d.release, nil
+}
+
+// acquire 取当前数据并持有一个引用
+func (rg *RGeocoder) acquire() (*loadedData, error) {
+	for {
+		d := rg.cur.Load()
+		if d.acquire() {
+			return d, nil
+		}
+		// 引用已归零：被 Reload 替换（重新读取）或已经 Close
+		if rg.cur.Load() == d {
+			return nil, ErrClosed
 		}
 	}
-	_, indices, err := rg.tree.Query(coordinates, 1)
+}
+
+// Query 批量查询；无匹配的点返回零值 Location（可用 QueryDetailed 的 Matched 区分）
+func (rg *RGeocoder) Query(coordinates []Coordinate) ([]Location, error) {
+	return rg.QueryContext(context.Background(), coordinates)
+}
+
+// QueryContext 同 Query，ctx 取消或超时后尽快返回 ctx.Err()
+func (rg *RGeocoder) QueryContext(ctx context.Context, coordinates []Coordinate) ([]Location, error) {
+	ix, release, err := rg.index()
 	if err != nil {
 		return nil, err
 	}
-	results := make([]Location, 0, len(indices))
-	for _, idx := range indices {
-		if idx >= 0 && idx < len(rg.locations) {
-			results = append(results, rg.locations[idx])
-		} else {
-			results = append(results, Location{})
-		}
+	defer release()
+	matches, err := ix.QueryContext(ctx, coordinates)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Location, len(matches))
+	for i, m := range matches {
+		results[i] = m.Item
+	}
+	return results, nil
+}
+
+// QueryDetailed 批量查询，并返回每个匹配的索引、距离(km)、方位角与数值坐标
+func (rg *RGeocoder) QueryDetailed(coordinates []Coordinate) ([]QueryResult, error) {
+	return rg.QueryDetailedContext(context.Background(), coordinates)
+}
+
+// QueryDetailedContext 同 QueryDetailed，支持取消
+func (rg *RGeocoder) QueryDetailedContext(ctx context.Context, coordinates []Coordinate) ([]QueryResult, error) {
+	ix, release, err := rg.index()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	results := make([]QueryResult, len(coordinates))
+	err = ix.nearestDo(ctx, coordinates, func(i, ref int) {
+		results[i] = locationDetail(ix, coordinates[i], ref)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return results, nil
 }
 
-// QuerySingle 单个查询
+// locationDetail 组装详细结果（调用方持有索引的读锁）；ref 无效时返回 Matched=false、Index=-1 的空结果（数值字段为0，便于JSON序列化）
+func locationDetail(ix *Index[Location], q Coordinate, ref int) QueryResult {
+	if ref < 0 {
+		return QueryResult{Index: -1}
+	}
+	p := ix.coordAt(ref)
+	return QueryResult{
+		Matched:  true,
+		Location: ix.itemAt(ref),
+		Index:    ix.idAt(ref),
+		Distance: HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon),
+		Bearing:  InitialBearing(q.Lat, q.Lon, p.Lat, p.Lon),
+		Lat:      p.Lat,
+		Lon:      p.Lon,
+	}
+}
+
+// QueryK 返回每个坐标最近的 k 个位置（按距离由近到远），Distance 为球面距离(km)
+func (rg *RGeocoder) QueryK(coordinates []Coordinate, k int) ([][]Neighbor, error) {
+	return rg.QueryKContext(context.Background(), coordinates, k)
+}
+
+// QueryKContext 同 QueryK，支持取消
+func (rg *RGeocoder) QueryKContext(ctx context.Context, coordinates []Coordinate, k int) ([][]Neighbor, error) {
+	ix, release, err := rg.index()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	matches, err := ix.QueryKContext(ctx, coordinates, k)
+	if err != nil {
+		return nil, err
+	}
+	results := make([][]Neighbor, len(matches))
+	for i, row := range matches {
+		results[i] = neighbors(row)
+	}
+	return results, nil
+}
+
+// QueryRadius 返回 radiusKm 公里内的所有位置，按距离由近到远；limit > 0 时最多返回 limit 个
+func (rg *RGeocoder) QueryRadius(coord Coordinate, radiusKm float64, limit int) ([]Neighbor, error) {
+	ix, release, err := rg.index()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	matches, err := ix.QueryRadius(coord, radiusKm, limit)
+	if err != nil {
+		return nil, err
+	}
+	return neighbors(matches), nil
+}
+
+// neighbors Match[Location] 转为 Neighbor
+func neighbors(matches []Match[Location]) []Neighbor {
+	out := make([]Neighbor, len(matches))
+	for i, m := range matches {
+		out[i] = Neighbor{Location: m.Item, Index: m.Index, Distance: m.Distance}
+	}
+	return out
+}
+
+// QueryBBox 返回经纬度矩形内（含边界）的所有位置；minLon > maxLon 表示矩形跨越反子午线
+func (rg *RGeocoder) QueryBBox(minLat, minLon, maxLat, maxLon float64) ([]Location, error) {
+	ix, release, err := rg.index()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return ix.QueryBBox(minLat, minLon, maxLat, maxLon)
+}
+
+// QueryPolygon 返回多边形（单个外环，首尾可闭合也可不闭合）内的所有位置。
+// 跨越反子午线的环会先展开经度；包含极点的环不支持。
+func (rg *RGeocoder) QueryPolygon(ring []Coordinate) ([]Location, error) {
+	ix, release, err := rg.index()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return ix.QueryPolygon(ring)
+}
+
+// QuerySingle 单个查询；无匹配时返回 ErrNoMatch
 func (rg *RGeocoder) QuerySingle(c Coordinate) (Location, error) {
-	locs, err := rg.Query([]Coordinate{c})
-	if err != nil || len(locs) == 0 {
+	return rg.QuerySingleContext(context.Background(), c)
+}
+
+// QuerySingleContext 同 QuerySingle，支持取消
+func (rg *RGeocoder) QuerySingleContext(ctx context.Context, c Coordinate) (Location, error) {
+	ix, release, err := rg.index()
+	if err != nil {
 		return Location{}, err
 	}
-	return locs[0], nil
+	defer release()
+	m, err := ix.QuerySingleContext(ctx, c)
+	return m.Item, err
+}
+
+// Add 新增位置并返回其 ID（即查询结果中的 Index），立即对查询可见；Reload 会丢弃之前的修改
+func (rg *RGeocoder) Add(c Coordinate, loc Location) (int, error) {
+	d, err := rg.acquire()
+	if err != nil {
+		return -1, err
+	}
+	defer d.release()
+	d.modified.Store(true)
+	return d.idx.Add(c, loc)
 }
 
-// Close 释放资源（当前无状态）
-func (rg *RGeocoder) Close() error { return nil }
+// Remove 删除位置；ID 不存在时返回 ErrNotFound
+func (rg *RGeocoder) Remove(id int) error {
+	d, err := rg.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
+	d.modified.Store(true)
+	return d.idx.Remove(id)
+}
+
+// Update 替换位置的坐标与内容，ID 不变
+func (rg *RGeocoder) Update(id int, c Coordinate, loc Location) error {
+	d, err := rg.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
+	d.modified.Store(true)
+	return d.idx.Update(id, c, loc)
+}
+
+// Rebuild 立即把增量修改合并进KD树（通常由 WithRebuildThreshold 自动触发）
+func (rg *RGeocoder) Rebuild() {
+	ix, release, err := rg.index()
+	if err != nil {
+		return
+	}
+	defer release()
+	ix.Rebuild()
+}
+
+// Close 停止文件监视并释放资源（如多线程KD树的worker池、mmap 映射）；
+// 进行中的查询与 Index 的持有者释放后才真正关闭索引。之后的查询返回 ErrClosed，可重复调用。
+func (rg *RGeocoder) Close() error {
+	rg.stopWatch()
+	// 持有 reloadMu 时当前数据不会被替换；进行中的 Reload 之后会发现已关闭
+	rg.reloadMu.Lock()
+	defer rg.reloadMu.Unlock()
+	if !rg.closed {
+		rg.closed = true
+		rg.cur.Load().release()
+	}
+	return nil
+}
+
+// locationStore 位置数据存储：内存切片或 mmap 映射
+type locationStore = itemStore[Location]
+
+// locationSlice 内存中的位置切片
+type locationSlice = itemSlice[Location]
 
 // Get 便捷函数
 func Get(coord Coordinate, opts ...Option) (Location, error) {
@@ -0,0 +1,92 @@
+package rgeocoder
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+)
+
+// candidate 搜索过程中的候选点
+type candidate struct {
+	index int
+	dist  float64
+}
+
+// boundedHeap 容量为 k 的最大堆，堆顶是当前第 k 近的候选
+type boundedHeap struct {
+	k     int
+	items []candidate
+}
+
+func newBoundedHeap(k int) *boundedHeap {
+	return &boundedHeap{k: k, items: make([]candidate, 0, min(k, 64))}
+}
+
+func (h *boundedHeap) Len() int           { return len(h.items) }
+func (h *boundedHeap) Less(i, j int) bool { return h.items[i].dist > h.items[j].dist }
+func (h *boundedHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *boundedHeap) Push(x any)         { h.items = append(h.items, x.(candidate)) }
+func (h *boundedHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// bound 剪枝阈值：未满 k 个时为 +Inf
+func (h *boundedHeap) bound() float64 {
+	if len(h.items) < h.k {
+		return math.Inf(1)
+	}
+	return h.items[0].dist
+}
+
+// offer 若比当前第 k 近更近则加入
+func (h *boundedHeap) offer(index int, dist float64) {
+	if len(h.items) < h.k {
+		heap.Push(h, candidate{index: index, dist: dist})
+		return
+	}
+	if dist < h.items[0].dist {
+		h.items[0] = candidate{index: index, dist: dist}
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted 按距离升序返回全部候选
+func (h *boundedHeap) sorted() []candidate {
+	// slices.SortFunc 不像 sort.Slice 那样每次分配
+	slices.SortFunc(h.items, func(a, b candidate) int { return cmp.Compare(a.dist, b.dist) })
+	return h.items
+}
+
+// fill 按距离升序写入 dists/indices（长度 k），不足的位置填 +Inf / -1
+func (h *boundedHeap) fill(dists []float64, indices []int) {
+	h.sorted()
+	for i := range dists {
+		if i < len(h.items) {
+			dists[i], indices[i] = h.items[i].dist, h.items[i].index
+		} else {
+			dists[i], indices[i] = math.Inf(1), -1
+		}
+	}
+}
+
+// split 按距离升序拆成距离与索引两个切片
+func (h *boundedHeap) split() ([]float64, []int) {
+	items := h.sorted()
+	dists := make([]float64, len(items))
+	indices := make([]int, len(items))
+	for i, c := range items {
+		dists[i], indices[i] = c.dist, c.index
+	}
+	return dists, indices
+}
+
+// radiusCapacity 半径检索的堆容量：limit <= 0 表示不限
+func radiusCapacity(limit int) int {
+	if limit <= 0 {
+		return math.MaxInt
+	}
+	return limit
+}
@@ -0,0 +1,540 @@
+package rgeocoder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Match 泛型索引的一个查询结果
+type Match[T any] struct {
+	Item     T       `json:"item"`
+	Index    int     `json:"index"`       // 条目 ID（未修改过的索引即构建时切片中的下标），无匹配时为 -1
+	Distance float64 `json:"distance_km"` // 查询点到匹配点的球面距离(km)
+}
+
+// itemStore 按下标读取载荷：内存切片或 mmap 映射
+type itemStore[T any] interface {
+	Len() int
+	At(i int) T
+}
+
+// itemSlice 内存中的载荷切片
+type itemSlice[T any] []T
+
+func (s itemSlice[T]) Len() int   { return len(s) }
+func (s itemSlice[T]) At(i int) T { return s[i] }
+
+// Index 泛型索引：坐标与任意类型的载荷一一对应（门店、基站、仓库等）。
+// RGeocoder 就是 Index[Location] 加上数据集的加载逻辑。
+//
+// 条目以 ID 标识：构建时为切片下标，Add 依次分配新的 ID。新增与更新的条目先进入增量缓冲
+// （查询时线性扫描），删除只打标记；两者累计到 WithRebuildThreshold 后在后台重建KD树。
+// 所有方法可并发调用。
+type Index[T any] struct {
+	mu     sync.RWMutex
+	config *Config
+
+	// 基础数据：KD树及其位置上的坐标与载荷，只在重建时整体替换
+	tree    KDTreeInterface
+	coords  []Coordinate
+	items   itemStore[T]
+	ids     []int       // 位置 -> ID；nil 表示 ID 即位置
+	pos     map[int]int // ID -> 位置；nil 表示位置即 ID
+	dead    []bool      // 已删除（或已更新到增量缓冲）的位置，首次删除时分配
+	removed int
+
+	// 增量缓冲：上次重建后新增或更新的条目
+	delta    []entry[T]
+	deltaPos map[int]int // ID -> delta 下标
+	nextID   int
+
+	rebuildMu  sync.Mutex    // 串行化重建
+	rebuilding bool          // 重建进行中，期间的修改记入 pending
+	pending    []mutation[T] // 换入新树后重放
+	wg         sync.WaitGroup
+	closed     bool
+}
+
+// NewIndex 由坐标与等长的载荷切片建索引。WithMode、WithMaxWorkers、WithDistanceMode、
+// WithTreeLayout、WithMaxDistance、WithRebuildThreshold 生效，数据集相关的选项被忽略。
+// items 不会被复制，建索引后不要修改（用 Update 修改条目）。
+func NewIndex[T any](coords []Coordinate, items []T, opts ...Option) (*Index[T], error) {
+	if len(coords) != len(items) {
+		return nil, fmt.Errorf("coords and items length mismatch: %d != %d", len(coords), len(items))
+	}
+	if err := ValidateCoordinates(coords); err != nil {
+		return nil, err
+	}
+	cfg := applyOptions(opts)
+	coords = slices.Clone(coords)
+	return newIndex[T](cfg, coords, itemSlice[T](items), buildTree(coords, cfg)), nil
+}
+
+func newIndex[T any](cfg *Config, coords []Coordinate, items itemStore[T], tree KDTreeInterface) *Index[T] {
+	return &Index[T]{config: cfg, tree: tree, coords: coords, items: items, deltaPos: make(map[int]int), nextID: len(coords)}
+}
+
+// Len 存活条目数量
+func (ix *Index[T]) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.coords) - ix.removed + len(ix.delta)
+}
+
+// Get 按 ID 读取条目
+func (ix *Index[T]) Get(id int) (Coordinate, T, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if j, ok := ix.deltaPos[id]; ok {
+		return ix.delta[j].coord, ix.delta[j].item, true
+	}
+	if p, ok := ix.basePos(id); ok && !ix.isDead(p) {
+		return ix.coords[p], ix.items.At(p), true
+	}
+	var zero T
+	return Coordinate{}, zero, false
+}
+
+// each 遍历所有存活条目（顺序不定）
+func (ix *Index[T]) each(fn func(T)) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for p := range ix.coords {
+		if !ix.isDead(p) {
+			fn(ix.items.At(p))
+		}
+	}
+	for _, e := range ix.delta {
+		fn(e.item)
+	}
+}
+
+// 查询内部用“引用”指向条目：[0, len(coords)) 为基础数据位置，其后为增量缓冲下标。
+// 引用只在持有锁期间有效，对外一律转换为 ID。
+
+func (ix *Index[T]) coordAt(ref int) Coordinate {
+	if n := len(ix.coords); ref >= n {
+		return ix.delta[ref-n].coord
+	}
+	return ix.coords[ref]
+}
+
+func (ix *Index[T]) itemAt(ref int) T {
+	if n := len(ix.coords); ref >= n {
+		return ix.delta[ref-n].item
+	}
+	return ix.items.At(ref)
+}
+
+func (ix *Index[T]) idAt(ref int) int {
+	if n := len(ix.coords); ref >= n {
+		return ix.delta[ref-n].id
+	}
+	if ix.ids == nil {
+		return ref
+	}
+	return ix.ids[ref]
+}
+
+// Query 批量最近邻查询；无匹配的点返回 Index=-1、Item 为零值的结果
+func (ix *Index[T]) Query(coordinates []Coordinate) ([]Match[T], error) {
+	return ix.QueryContext(context.Background(), coordinates)
+}
+
+// QueryContext 同 Query，支持取消
+func (ix *Index[T]) QueryContext(ctx context.Context, coordinates []Coordinate) ([]Match[T], error) {
+	results := make([]Match[T], len(coordinates))
+	err := ix.nearestDo(ctx, coordinates, func(i, ref int) {
+		if ref >= 0 {
+			results[i] = ix.match(coordinates[i], ref)
+		} else {
+			results[i].Index = -1
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QuerySingle 单点最近邻；无匹配时返回 ErrNoMatch
+func (ix *Index[T]) QuerySingle(c Coordinate) (Match[T], error) {
+	return ix.QuerySingleContext(context.Background(), c)
+}
+
+// QuerySingleContext 同 QuerySingle，支持取消
+func (ix *Index[T]) QuerySingleContext(ctx context.Context, c Coordinate) (Match[T], error) {
+	results, err := ix.QueryContext(ctx, []Coordinate{c})
+	if err != nil {
+		return Match[T]{Index: -1}, err
+	}
+	if results[0].Index < 0 {
+		return results[0], ErrNoMatch
+	}
+	return results[0], nil
+}
+
+// nearestDo 在读锁内求每个点的最近邻引用并回调 fn；无匹配或超出最大距离时 ref 为 -1
+func (ix *Index[T]) nearestDo(ctx context.Context, coordinates []Coordinate, fn func(i, ref int)) error {
+	if err := validateQuery(coordinates); err != nil {
+		return err
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	refs, err := ix.knn(ctx, coordinates, 1)
+	if err != nil {
+		return err
+	}
+	for i, ref := range refs {
+		if ref >= 0 && !ix.withinMaxDistance(coordinates[i], ref) {
+			ref = -1
+		}
+		fn(i, ref)
+	}
+	return nil
+}
+
+// knn 每个点最近的 k 个存活条目的引用，布局同 KDTreeInterface.Query（不足以 -1 填充）。
+// 基础树的结果先去掉已删除的位置，再与增量缓冲按同一度量合并。调用方持有读锁。
+func (ix *Index[T]) knn(ctx context.Context, coordinates []Coordinate, k int) ([]int, error) {
+	_, refs, err := ix.tree.QueryContext(ctx, coordinates, k)
+	if err != nil {
+		return nil, err
+	}
+	if ix.removed > 0 {
+		if err := ix.skipDead(ctx, coordinates, k, refs); err != nil {
+			return nil, err
+		}
+	}
+	if len(ix.delta) > 0 {
+		ix.mergeDelta(coordinates, k, refs)
+	}
+	return refs, nil
+}
+
+// skipDead 去掉已删除的位置；某行存活的不足 k 个且树中还有更多点时，对这些点加倍 k 重查
+func (ix *Index[T]) skipDead(ctx context.Context, coordinates []Coordinate, k int, refs []int) error {
+	var todo []int
+	for i := range coordinates {
+		row := refs[i*k : (i+1)*k]
+		if !ix.compactRow(row, row) {
+			todo = append(todo, i)
+		}
+	}
+	// 每行最多有 removed 个已删除的位置，k+removed 一定够用
+	for kk := min(2*k, k+ix.removed); len(todo) > 0; kk = min(2*kk, k+ix.removed) {
+		qs := make([]Coordinate, len(todo))
+		for j, i := range todo {
+			qs[j] = coordinates[i]
+		}
+		_, more, err := ix.tree.QueryContext(ctx, qs, kk)
+		if err != nil {
+			return err
+		}
+		next := todo[:0]
+		for j, i := range todo {
+			if !ix.compactRow(refs[i*k:(i+1)*k], more[j*kk:(j+1)*kk]) && kk < k+ix.removed {
+				next = append(next, i)
+			}
+		}
+		todo = next
+	}
+	return nil
+}
+
+// compactRow 把 src 中存活的位置按序写入 dst（可与 src 重叠），其余填 -1。
+// src 被填满（树中可能还有更多点）而存活的不足 len(dst) 个时返回 false。
+func (ix *Index[T]) compactRow(dst, src []int) bool {
+	n, exhausted := 0, false
+	for _, ref := range src {
+		if ref < 0 {
+			exhausted = true
+			continue
+		}
+		if n < len(dst) && !ix.dead[ref] {
+			dst[n] = ref
+			n++
+		}
+	}
+	for j := n; j < len(dst); j++ {
+		dst[j] = -1
+	}
+	return n == len(dst) || exhausted
+}
+
+// mergeDelta 把增量缓冲中的条目并入每行的 k 近邻
+func (ix *Index[T]) mergeDelta(coordinates []Coordinate, k int, refs []int) {
+	n := len(ix.coords)
+	dists := make([]float64, k)
+	for i, q := range coordinates {
+		row := refs[i*k : (i+1)*k]
+		best := newBoundedHeap(k)
+		for _, ref := range row {
+			if ref >= 0 {
+				best.offer(ref, metricDistance(ix.config.DistanceMode, q, ix.coords[ref]))
+			}
+		}
+		for j, e := range ix.delta {
+			best.offer(n+j, metricDistance(ix.config.DistanceMode, q, e.coord))
+		}
+		best.fill(dists, row)
+	}
+}
+
+// withinMaxDistance 是否满足 WithMaxDistance 截断
+func (ix *Index[T]) withinMaxDistance(q Coordinate, ref int) bool {
+	if ix.config.MaxDistance <= 0 {
+		return true
+	}
+	p := ix.coordAt(ref)
+	return HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon) <= ix.config.MaxDistance
+}
+
+// QueryK 返回每个坐标最近的 k 个载荷（按距离由近到远）
+func (ix *Index[T]) QueryK(coordinates []Coordinate, k int) ([][]Match[T], error) {
+	return ix.QueryKContext(context.Background(), coordinates, k)
+}
+
+// QueryKContext 同 QueryK，支持取消
+func (ix *Index[T]) QueryKContext(ctx context.Context, coordinates []Coordinate, k int) ([][]Match[T], error) {
+	if err := validateQuery(coordinates); err != nil {
+		return nil, err
+	}
+	if k < 1 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	refs, err := ix.knn(ctx, coordinates, k)
+	if err != nil {
+		return nil, err
+	}
+	results := make([][]Match[T], len(coordinates))
+	for i, q := range coordinates {
+		row := make([]Match[T], 0, k)
+		for _, ref := range refs[i*k : (i+1)*k] {
+			if ref >= 0 && ix.withinMaxDistance(q, ref) {
+				row = append(row, ix.match(q, ref))
+			}
+		}
+		// 树内度量（平面/弦距离）与球面距离排序可能略有差别，按返回的距离重新排序
+		sort.SliceStable(row, func(a, b int) bool { return row[a].Distance < row[b].Distance })
+		results[i] = row
+	}
+	return results, nil
+}
+
+// QueryRadius 返回 radiusKm 公里内的所有载荷，按距离由近到远；limit > 0 时最多返回 limit 个
+func (ix *Index[T]) QueryRadius(coord Coordinate, radiusKm float64, limit int) ([]Match[T], error) {
+	if err := validateQuery([]Coordinate{coord}); err != nil {
+		return nil, err
+	}
+	if !(radiusKm >= 0) {
+		return nil, fmt.Errorf("invalid radius: %v", radiusKm)
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	baseLimit := limit
+	if limit > 0 {
+		baseLimit += ix.removed
+	}
+	var refs []int
+	if rq, ok := ix.tree.(RadiusQuerier); ok {
+		_, refs = rq.QueryRadius(coord, radiusKm, baseLimit)
+	} else {
+		_, refs = bruteForceRadius(ix.coords, coord, radiusKm, baseLimit)
+	}
+	results := make([]Match[T], 0, len(refs))
+	for _, ref := range refs {
+		if ref >= 0 && !ix.isDead(ref) {
+			results = append(results, ix.match(coord, ref))
+		}
+	}
+	if len(ix.delta) > 0 {
+		n := len(ix.coords)
+		for j, e := range ix.delta {
+			if haversine(coord.Lat, coord.Lon, e.coord.Lat, e.coord.Lon) <= radiusKm {
+				results = append(results, ix.match(coord, n+j))
+			}
+		}
+		sort.SliceStable(results, func(a, b int) bool { return results[a].Distance < results[b].Distance })
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// bruteForceRadius 不支持半径检索的树实现退化为线性扫描
+func bruteForceRadius(coords []Coordinate, c Coordinate, radiusKm float64, limit int) ([]float64, []int) {
+	best := newBoundedHeap(radiusCapacity(limit))
+	for i, p := range coords {
+		if d := haversine(c.Lat, c.Lon, p.Lat, p.Lon); d <= radiusKm {
+			best.offer(i, d)
+		}
+	}
+	return best.split()
+}
+
+// QueryBBox 返回经纬度矩形内（含边界）的所有载荷；minLon > maxLon 表示矩形跨越反子午线
+func (ix *Index[T]) QueryBBox(minLat, minLon, maxLat, maxLon float64) ([]T, error) {
+	if err := validateBBox(minLat, minLon, maxLat, maxLon); err != nil {
+		return nil, err
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.itemsAt(ix.queryBBox(minLat, minLon, maxLat, maxLon)), nil
+}
+
+// QueryPolygon 返回多边形（单个外环，首尾可闭合也可不闭合）内的所有载荷。
+// 跨越反子午线的环会先展开经度；包含极点的环不支持。
+func (ix *Index[T]) QueryPolygon(ring []Coordinate) ([]T, error) {
+	if len(ring) < 3 {
+		return nil, fmt.Errorf("polygon needs at least 3 vertices, got %d", len(ring))
+	}
+	if err := ValidateCoordinates(ring); err != nil {
+		return nil, err
+	}
+	unwrapped, err := unwrapRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	minLat, minLon, maxLat, maxLon := ringBounds(unwrapped)
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var candidates []int
+	if maxLon-minLon >= 360 {
+		candidates = ix.queryBBox(minLat, -180, maxLat, 180)
+	} else {
+		candidates = ix.queryBBox(minLat, normalizeLon(minLon), maxLat, normalizeLon(maxLon))
+	}
+	inside := candidates[:0]
+	for _, ref := range candidates {
+		p := ix.coordAt(ref)
+		// 把点的经度平移到展开后环的经度区间内再做射线法判断
+		lon := p.Lon
+		for lon < minLon {
+			lon += 360
+		}
+		for lon-360 >= minLon {
+			lon -= 360
+		}
+		if pointInRing(Coordinate{Lat: p.Lat, Lon: lon}, unwrapped) {
+			inside = append(inside, ref)
+		}
+	}
+	return ix.itemsAt(inside), nil
+}
+
+// queryBBox 优先使用树的矩形检索，否则线性扫描；结果含增量缓冲中的条目。调用方持有读锁。
+func (ix *Index[T]) queryBBox(minLat, minLon, maxLat, maxLon float64) []int {
+	var refs []int
+	if rq, ok := ix.tree.(RangeQuerier); ok {
+		refs = rq.QueryBBox(minLat, minLon, maxLat, maxLon)
+	} else {
+		refs = scanBBox(ix.coords, minLat, minLon, maxLat, maxLon)
+	}
+	if ix.removed > 0 {
+		live := refs[:0]
+		for _, ref := range refs {
+			if !ix.dead[ref] {
+				live = append(live, ref)
+			}
+		}
+		refs = live
+	}
+	if len(ix.delta) > 0 {
+		n := len(ix.coords)
+		parts := bboxParts(minLat, minLon, maxLat, maxLon)
+		for j, e := range ix.delta {
+			for _, b := range parts {
+				if b.contains(e.coord) {
+					refs = append(refs, n+j)
+					break
+				}
+			}
+		}
+	}
+	return refs
+}
+
+func (ix *Index[T]) itemsAt(refs []int) []T {
+	out := make([]T, 0, len(refs))
+	for _, ref := range refs {
+		if ref >= 0 {
+			out = append(out, ix.itemAt(ref))
+		}
+	}
+	return out
+}
+
+// scanBBox 线性扫描矩形检索
+func scanBBox(coords []Coordinate, minLat, minLon, maxLat, maxLon float64) []int {
+	var out []int
+	parts := bboxParts(minLat, minLon, maxLat, maxLon)
+	for i, c := range coords {
+		for _, b := range parts {
+			if b.contains(c) {
+				out = append(out, i)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func validateBBox(minLat, minLon, maxLat, maxLon float64) error {
+	if err := ValidateCoordinates([]Coordinate{{Lat: minLat, Lon: minLon}, {Lat: maxLat, Lon: maxLon}}); err != nil {
+		return fmt.Errorf("invalid bbox: %w", err)
+	}
+	if minLat > maxLat {
+		return fmt.Errorf("invalid bbox: minLat %v > maxLat %v", minLat, maxLat)
+	}
+	return nil
+}
+
+// match 组装单个结果
+func (ix *Index[T]) match(q Coordinate, ref int) Match[T] {
+	p := ix.coordAt(ref)
+	return Match[T]{Item: ix.itemAt(ref), Index: ix.idAt(ref), Distance: HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon)}
+}
+
+// validateQuery 校验查询坐标
+func validateQuery(coordinates []Coordinate) error {
+	if len(coordinates) == 0 {
+		return fmt.Errorf("no coordinates provided")
+	}
+	for _, c := range coordinates {
+		if c.Lat < -90 || c.Lat > 90 || c.Lon < -180 || c.Lon > 180 {
+			return fmt.Errorf("invalid coordinate: %+v", c)
+		}
+	}
+	return nil
+}
+
+// compacted 在没有增量与删除标记的状态下（必要时先重建）持读锁调用 fn
+func (ix *Index[T]) compacted(fn func(tree KDTreeInterface, coords []Coordinate, items itemStore[T]) error) error {
+	for {
+		ix.mu.RLock()
+		if ix.dirty() == 0 {
+			defer ix.mu.RUnlock()
+			return fn(ix.tree, ix.coords, ix.items)
+		}
+		ix.mu.RUnlock()
+		ix.Rebuild()
+	}
+}
+
+// Close 等待后台重建结束并释放资源（如多线程KD树的worker池、mmap 映射）
+func (ix *Index[T]) Close() error {
+	ix.mu.Lock()
+	ix.closed = true
+	ix.mu.Unlock()
+	ix.wg.Wait()
+	ix.rebuildMu.Lock()
+	defer ix.rebuildMu.Unlock()
+	return closeAll(ix.tree, ix.items)
+}
@@ -0,0 +1,228 @@
+package rgeocoder
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// defaultRebuildThreshold 增量条目与已删除条目之和达到该值时在后台重建KD树
+const defaultRebuildThreshold = 1024
+
+// ErrNotFound 指定的 ID 不存在（从未分配或已被删除）
+var ErrNotFound = errors.New("id not found")
+
+// entry 增量缓冲中的一个条目
+type entry[T any] struct {
+	id    int
+	coord Coordinate
+	item  T
+}
+
+// mutation 重建期间记录的修改；remove 为 false 时表示按 ID 写入 entry
+type mutation[T any] struct {
+	entry[T]
+	remove bool
+}
+
+// Add 新增条目并返回其 ID，立即对查询可见
+func (ix *Index[T]) Add(c Coordinate, item T) (int, error) {
+	if err := ValidateCoordinate(c); err != nil {
+		return -1, err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	id := ix.nextID
+	ix.nextID++
+	ix.apply(mutation[T]{entry: entry[T]{id: id, coord: c, item: item}})
+	return id, nil
+}
+
+// Remove 删除条目；ID 不存在时返回 ErrNotFound
+func (ix *Index[T]) Remove(id int) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.has(id) {
+		return ErrNotFound
+	}
+	ix.apply(mutation[T]{entry: entry[T]{id: id}, remove: true})
+	return nil
+}
+
+// Update 替换条目的坐标与载荷，ID 不变；ID 不存在时返回 ErrNotFound
+func (ix *Index[T]) Update(id int, c Coordinate, item T) error {
+	if err := ValidateCoordinate(c); err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.has(id) {
+		return ErrNotFound
+	}
+	ix.apply(mutation[T]{entry: entry[T]{id: id, coord: c, item: item}})
+	return nil
+}
+
+// apply 执行一次修改（调用方持有写锁）：先删除同 ID 的旧条目，非删除操作再写入增量缓冲。
+// 按 ID 覆盖写使重放幂等，重建时可以把期间的修改直接重放到新的基础数据上。
+func (ix *Index[T]) apply(m mutation[T]) {
+	ix.drop(m.id)
+	if !m.remove {
+		ix.deltaPos[m.id] = len(ix.delta)
+		ix.delta = append(ix.delta, m.entry)
+	}
+	if ix.rebuilding {
+		ix.pending = append(ix.pending, m)
+		return
+	}
+	if t := ix.rebuildThreshold(); t > 0 && ix.dirty() >= t && !ix.closed {
+		ix.rebuilding = true
+		ix.wg.Add(1)
+		go func() {
+			defer ix.wg.Done()
+			ix.Rebuild()
+		}()
+	}
+}
+
+// has ID 是否存活
+func (ix *Index[T]) has(id int) bool {
+	if _, ok := ix.deltaPos[id]; ok {
+		return true
+	}
+	p, ok := ix.basePos(id)
+	return ok && !ix.isDead(p)
+}
+
+// drop 删除 ID 对应的条目：增量缓冲中的直接移除，基础数据中的打删除标记
+func (ix *Index[T]) drop(id int) {
+	if j, ok := ix.deltaPos[id]; ok {
+		last := len(ix.delta) - 1
+		ix.delta[j] = ix.delta[last]
+		ix.deltaPos[ix.delta[j].id] = j
+		ix.delta[last] = entry[T]{}
+		ix.delta = ix.delta[:last]
+		delete(ix.deltaPos, id)
+		return
+	}
+	p, ok := ix.basePos(id)
+	if !ok || ix.isDead(p) {
+		return
+	}
+	if ix.dead == nil {
+		ix.dead = make([]bool, len(ix.coords))
+	}
+	ix.dead[p] = true
+	ix.removed++
+}
+
+// basePos ID 在基础数据中的位置
+func (ix *Index[T]) basePos(id int) (int, bool) {
+	if ix.pos == nil {
+		return id, id >= 0 && id < len(ix.coords)
+	}
+	p, ok := ix.pos[id]
+	return p, ok
+}
+
+func (ix *Index[T]) isDead(p int) bool { return ix.dead != nil && ix.dead[p] }
+
+// dirty 自上次重建以来的增量与删除数
+func (ix *Index[T]) dirty() int { return len(ix.delta) + ix.removed }
+
+func (ix *Index[T]) rebuildThreshold() int {
+	if ix.config.RebuildThreshold == 0 {
+		return defaultRebuildThreshold
+	}
+	return ix.config.RebuildThreshold
+}
+
+// Rebuild 把增量缓冲与删除标记合并进新的KD树。建树期间查询与修改照常进行：
+// 只在复制存活条目时持有读锁，换入新树时持有写锁并重放期间的修改。
+// 从 mmap 快照打开的索引重建后数据转入内存。
+func (ix *Index[T]) Rebuild() {
+	ix.rebuildMu.Lock()
+	defer ix.rebuildMu.Unlock()
+
+	ix.mu.Lock()
+	if ix.dirty() == 0 || ix.closed {
+		ix.rebuilding = false
+		ix.mu.Unlock()
+		return
+	}
+	ix.rebuilding = true
+	ix.pending = nil
+	ix.mu.Unlock()
+
+	ix.mu.RLock()
+	n := len(ix.coords) - ix.removed + len(ix.delta)
+	coords := make([]Coordinate, 0, n)
+	items := make([]T, 0, n)
+	ids := make([]int, 0, n)
+	identity := true
+	for p := range ix.coords {
+		if !ix.isDead(p) {
+			identity = identity && ix.idAt(p) == len(ids)
+			coords = append(coords, ix.coords[p])
+			items = append(items, ix.items.At(p))
+			ids = append(ids, ix.idAt(p))
+		}
+	}
+	for _, e := range ix.delta {
+		identity = identity && e.id == len(ids)
+		coords = append(coords, e.coord)
+		items = append(items, e.item)
+		ids = append(ids, e.id)
+	}
+	ix.mu.RUnlock()
+
+	var pos map[int]int
+	if identity {
+		ids = nil
+	} else {
+		pos = make(map[int]int, len(ids))
+		for p, id := range ids {
+			pos[id] = p
+		}
+	}
+	tree := buildTree(coords, ix.config)
+
+	ix.mu.Lock()
+	oldTree, oldItems := ix.tree, ix.items
+	ix.tree, ix.coords, ix.items = tree, coords, itemSlice[T](items)
+	ix.ids, ix.pos, ix.dead, ix.removed = ids, pos, nil, 0
+	ix.delta, ix.deltaPos = nil, make(map[int]int)
+	pending := ix.pending
+	ix.pending, ix.rebuilding = nil, false
+	for _, m := range pending {
+		ix.apply(m)
+	}
+	ix.mu.Unlock()
+
+	// 写锁保证此时已没有查询在使用旧树
+	closeAll(oldTree, oldItems)
+}
+
+// metricDistance 与KD树一致的距离度量，用于合并增量缓冲与树的候选
+func metricDistance(mode DistanceMode, a, b Coordinate) float64 {
+	switch mode {
+	case DistanceEuclideanDegrees:
+		return math.Hypot(a.Lat-b.Lat, a.Lon-b.Lon)
+	case DistanceECEF:
+		return math.Sqrt(sqDist3(toECEF(a), toECEF(b)))
+	}
+	return haversine(a.Lat, a.Lon, b.Lat, b.Lon)
+}
+
+// closeAll 关闭实现了 io.Closer 的对象，返回第一个错误
+func closeAll(xs ...any) error {
+	var err error
+	for _, x := range xs {
+		if c, ok := x.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
@@ -1,6 +1,8 @@
 package rgeocoder
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"sort"
 )
@@ -16,22 +18,20 @@ type Node struct {
 
 // KDTree 包含根节点与点集合
 type KDTree struct {
-	root           *Node
-	points         []Coordinate
-	distanceFun    func(a, b Coordinate) float64
-	haversinePrune bool
+	root        *Node
+	points      []Coordinate
+	mode        DistanceMode
+	distanceFun func(a, b Coordinate) float64
 }
 
 // NewKDTree 构建中位数分割KD树
 func NewKDTree(points []Coordinate, mode DistanceMode) *KDTree {
-	t := &KDTree{points: points}
-	mode = DistanceEuclideanDegrees
+	t := &KDTree{points: points, mode: mode}
 	if mode == DistanceEuclideanDegrees {
 		t.distanceFun = func(a, b Coordinate) float64 { return math.Hypot(a.Lat-b.Lat, a.Lon-b.Lon) }
-		t.haversinePrune = false
 	} else { // 默认Haversine
+		t.mode = DistanceHaversine
 		t.distanceFun = func(a, b Coordinate) float64 { return haversine(a.Lat, a.Lon, b.Lat, b.Lon) }
-		t.haversinePrune = true
 	}
 	if len(points) > 0 {
 		indices := make([]int, len(points))
@@ -62,64 +62,237 @@ func buildKD(pts []Coordinate, idxs []int, depth int) *Node {
 	return &Node{Point: pts[nodeIdx], Index: nodeIdx, Axis: axis, Left: left, Right: right}
 }
 
-// Query 支持 k=1 最近邻（忽略其它 k>1 情况）
+// Query k近邻查询。结果按行展开：第 i 个查询点的邻居位于 [i*k, (i+1)*k)，
+// 按距离升序；数据不足 k 个时以 +Inf / -1 填充。
 func (t *KDTree) Query(coords []Coordinate, k int) ([]float64, []int, error) {
-	if k != 1 {
-		k = 1
+	return t.QueryContext(context.Background(), coords, k)
+}
+
+// QueryContext 同 Query，每 ctxCheckInterval 个点检查一次 ctx 是否已取消
+func (t *KDTree) QueryContext(ctx context.Context, coords []Coordinate, k int) ([]float64, []int, error) {
+	if k < 1 {
+		return nil, nil, fmt.Errorf("k must be positive, got %d", k)
 	}
-	dists := make([]float64, len(coords))
-	indices := make([]int, len(coords))
+	dists := make([]float64, len(coords)*k)
+	indices := make([]int, len(coords)*k)
 	for i, q := range coords {
-		bestIdx := -1
-		bestDist := math.MaxFloat64
-		searchNNCustom(t.root, q, &bestIdx, &bestDist, t.distanceFun, t.haversinePrune)
-		if bestIdx == -1 {
-			dists[i] = math.NaN()
-			indices[i] = -1
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		s := nnSearch{target: q, distFn: t.distanceFun, best: newBoundedHeap(k)}
+		if t.mode == DistanceHaversine {
+			s.searchSpherical(t.root, worldBox)
 		} else {
-			dists[i] = bestDist
-			indices[i] = bestIdx
+			s.searchPlanar(t.root)
 		}
+		s.best.fill(dists[i*k:(i+1)*k], indices[i*k:(i+1)*k])
 	}
 	return dists, indices, nil
 }
 
-// 递归最近邻搜索
-func searchNNCustom(node *Node, target Coordinate, bestIdx *int, bestDist *float64, distFn func(a, b Coordinate) float64, haversinePrune bool) {
+// QueryRadius 返回与 c 球面距离不超过 radiusKm 的所有点（km，按距离升序），
+// limit > 0 时只保留最近的 limit 个。无论距离模式如何，都按经纬度包围盒剪枝。
+func (t *KDTree) QueryRadius(c Coordinate, radiusKm float64, limit int) ([]float64, []int) {
+	s := radiusSearch{target: c, radius: radiusKm, best: newBoundedHeap(radiusCapacity(limit))}
+	s.search(t.root, worldBox)
+	return s.best.split()
+}
+
+// radiusSearch 单次半径检索的状态
+type radiusSearch struct {
+	target Coordinate
+	radius float64
+	best   *boundedHeap
+}
+
+func (s *radiusSearch) bound() float64 { return math.Min(s.radius, s.best.bound()) }
+
+func (s *radiusSearch) search(node *Node, box geoBox) {
+	if node == nil || box.minDistance(s.target) > s.bound() {
+		return
+	}
+	if d := haversine(s.target.Lat, s.target.Lon, node.Point.Lat, node.Point.Lon); d <= s.radius {
+		s.best.offer(node.Index, d)
+	}
+	leftBox, rightBox := box.split(node)
+	s.search(node.Left, leftBox)
+	s.search(node.Right, rightBox)
+}
+
+// QueryBBox 返回落在经纬度矩形内（含边界）的所有点索引（升序）。
+// minLon > maxLon 表示矩形跨越反子午线。
+func (t *KDTree) QueryBBox(minLat, minLon, maxLat, maxLon float64) []int {
+	var out []int
+	for _, b := range bboxParts(minLat, minLon, maxLat, maxLon) {
+		rangeSearch(t.root, b, &out)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// rangeSearch 利用分割轴只进入与矩形相交的子树
+func rangeSearch(node *Node, b geoBox, out *[]int) {
 	if node == nil {
 		return
 	}
-	d := distFn(target, node.Point)
-	if d < *bestDist {
-		*bestDist = d
-		*bestIdx = node.Index
+	if b.contains(node.Point) {
+		*out = append(*out, node.Index)
 	}
-	var goLeft bool
+	split, lo, hi := node.Point.Lon, b.minLon, b.maxLon
 	if node.Axis == 0 {
-		goLeft = target.Lat < node.Point.Lat
-	} else {
-		goLeft = target.Lon < node.Point.Lon
+		split, lo, hi = node.Point.Lat, b.minLat, b.maxLat
+	}
+	if lo <= split {
+		rangeSearch(node.Left, b, out)
+	}
+	if hi >= split {
+		rangeSearch(node.Right, b, out)
+	}
+}
+
+// ctxCheckInterval 批量查询中检查取消的间隔（点数）
+const ctxCheckInterval = 1024
+
+// nnSearch 单次k近邻搜索的状态
+type nnSearch struct {
+	target Coordinate
+	distFn func(a, b Coordinate) float64
+	best   *boundedHeap
+}
+
+func (s *nnSearch) visit(node *Node) {
+	s.best.offer(node.Index, s.distFn(s.target, node.Point))
+}
+
+// searchPlanar 平面欧氏距离：到分割线的轴向差即为下界
+func (s *nnSearch) searchPlanar(node *Node) {
+	if node == nil {
+		return
+	}
+	s.visit(node)
+	split, q := node.Point.Lon, s.target.Lon
+	if node.Axis == 0 {
+		split, q = node.Point.Lat, s.target.Lat
 	}
 	first, second := node.Left, node.Right
-	if !goLeft {
+	if q >= split {
 		first, second = second, first
 	}
-	searchNNCustom(first, target, bestIdx, bestDist, distFn, haversinePrune)
-	var axisDiff float64
-	if node.Axis == 0 {
-		axisDiff = math.Abs(target.Lat - node.Point.Lat)
-	} else {
-		axisDiff = math.Abs(target.Lon - node.Point.Lon)
+	s.searchPlanar(first)
+	if math.Abs(q-split) < s.best.bound() {
+		s.searchPlanar(second)
 	}
-	if haversinePrune {
-		if axisDiff*111.0 < *bestDist {
-			searchNNCustom(second, target, bestIdx, bestDist, distFn, haversinePrune)
-		}
+}
+
+// searchSpherical 球面距离：用子树经纬度包围盒到目标点的最小大圆距离剪枝
+func (s *nnSearch) searchSpherical(node *Node, box geoBox) {
+	if node == nil {
+		return
+	}
+	s.visit(node)
+	leftBox, rightBox := box.split(node)
+	first, second := node.Left, node.Right
+	firstBox, secondBox := leftBox, rightBox
+	if !node.goesLeft(s.target) {
+		first, second = second, first
+		firstBox, secondBox = secondBox, firstBox
+	}
+	if first != nil && firstBox.minDistance(s.target) < s.best.bound() {
+		s.searchSpherical(first, firstBox)
+	}
+	if second != nil && secondBox.minDistance(s.target) < s.best.bound() {
+		s.searchSpherical(second, secondBox)
+	}
+}
+
+// goesLeft 目标点是否落在节点左侧
+func (n *Node) goesLeft(c Coordinate) bool {
+	if n.Axis == 0 {
+		return c.Lat < n.Point.Lat
+	}
+	return c.Lon < n.Point.Lon
+}
+
+// geoBox 经纬度包围盒（闭区间，不跨越反子午线）
+type geoBox struct {
+	minLat, maxLat, minLon, maxLon float64
+}
+
+var worldBox = geoBox{minLat: -90, maxLat: 90, minLon: -180, maxLon: 180}
+
+// bboxParts 把可能跨越反子午线的矩形拆成不跨越的一到两个 geoBox
+func bboxParts(minLat, minLon, maxLat, maxLon float64) []geoBox {
+	if minLon <= maxLon {
+		return []geoBox{{minLat: minLat, maxLat: maxLat, minLon: minLon, maxLon: maxLon}}
+	}
+	return []geoBox{
+		{minLat: minLat, maxLat: maxLat, minLon: minLon, maxLon: 180},
+		{minLat: minLat, maxLat: maxLat, minLon: -180, maxLon: maxLon},
+	}
+}
+
+func (b geoBox) contains(c Coordinate) bool {
+	return c.Lat >= b.minLat && c.Lat <= b.maxLat && c.Lon >= b.minLon && c.Lon <= b.maxLon
+}
+
+// split 按节点分割面切成左右两个子盒（相等值两侧都可能出现，因此都取闭区间）
+func (b geoBox) split(n *Node) (left, right geoBox) {
+	if n.Axis == 0 {
+		return b.splitAxis(0, n.Point.Lat)
+	}
+	return b.splitAxis(1, n.Point.Lon)
+}
+
+// splitAxis 沿 axis（0=纬度，1=经度）在 v 处切分
+func (b geoBox) splitAxis(axis int, v float64) (left, right geoBox) {
+	left, right = b, b
+	if axis == 0 {
+		left.maxLat, right.minLat = v, v
 	} else {
-		if axisDiff < *bestDist {
-			searchNNCustom(second, target, bestIdx, bestDist, distFn, haversinePrune)
-		}
+		left.maxLon, right.minLon = v, v
+	}
+	return left, right
+}
+
+// minDistance 目标点到盒内任意点的最小大圆距离(km)。
+// 经度落在盒内时，最近点在同一子午线上；否则最近点必在经度差较小的那条边界子午线上
+// （固定纬度时距离随 |Δλ| 单调增加）。
+func (b geoBox) minDistance(c Coordinate) float64 {
+	if c.Lon >= b.minLon && c.Lon <= b.maxLon {
+		lat := math.Max(b.minLat, math.Min(b.maxLat, c.Lat))
+		return math.Abs(lat-c.Lat) * math.Pi / 180 * EarthRadius
+	}
+	lon := b.minLon
+	if lonDelta(c.Lon, b.maxLon) < lonDelta(c.Lon, b.minLon) {
+		lon = b.maxLon
+	}
+	return meridianDistance(c, lon, b.minLat, b.maxLat)
+}
+
+// lonDelta 两个经度之间的最小夹角(度)，考虑反子午线环绕
+func lonDelta(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// meridianDistance 点到经线 lon 上纬度区间 [lo, hi] 的最小大圆距离(km)。
+// 沿子午线 cos(d) = sinφq·sinφ + cosφq·cosφ·cosΔλ = C·cos(φ-φ0)，
+// 区间上的最大值只能在端点或 φ0 处取得。
+func meridianDistance(c Coordinate, lon, lo, hi float64) float64 {
+	toRad := math.Pi / 180
+	phiQ := c.Lat * toRad
+	dLon := (lon - c.Lon) * toRad
+	phi0 := math.Atan2(math.Sin(phiQ), math.Cos(phiQ)*math.Cos(dLon)) / toRad
+	d := math.Min(haversine(c.Lat, c.Lon, lo, lon), haversine(c.Lat, c.Lon, hi, lon))
+	if phi0 > lo && phi0 < hi {
+		d = math.Min(d, haversine(c.Lat, c.Lon, phi0, lon))
 	}
+	return d
 }
 
 // Haversine 计算球面距离 (km)
@@ -0,0 +1,148 @@
+package rgeocoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ecefNode 三维K-D树节点
+type ecefNode struct {
+	point [3]float64
+	index int
+	axis  int // 0:x 1:y 2:z
+	left  *ecefNode
+	right *ecefNode
+}
+
+// ECEFTree 在WGS84 ECEF坐标上构建的三维K-D树，距离为直线(弦)距离(km)。
+// 三维空间没有经度边界，天然处理反子午线与两极。
+type ECEFTree struct {
+	root   *ecefNode
+	points [][3]float64
+	coords []Coordinate
+}
+
+// NewECEFTree 构建三维中位数分割KD树
+func NewECEFTree(points []Coordinate) *ECEFTree {
+	t := &ECEFTree{points: make([][3]float64, len(points)), coords: points}
+	for i, c := range points {
+		t.points[i] = toECEF(c)
+	}
+	if len(points) > 0 {
+		indices := make([]int, len(points))
+		for i := range indices {
+			indices[i] = i
+		}
+		t.root = buildECEF(t.points, indices, 0)
+	}
+	return t
+}
+
+func buildECEF(pts [][3]float64, idxs []int, depth int) *ecefNode {
+	if len(idxs) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.SliceStable(idxs, func(i, j int) bool { return pts[idxs[i]][axis] < pts[idxs[j]][axis] })
+	m := len(idxs) / 2
+	nodeIdx := idxs[m]
+	return &ecefNode{
+		point: pts[nodeIdx],
+		index: nodeIdx,
+		axis:  axis,
+		left:  buildECEF(pts, idxs[:m], depth+1),
+		right: buildECEF(pts, idxs[m+1:], depth+1),
+	}
+}
+
+// Query k近邻查询，返回弦距离(km)，结果布局同 KDTree.Query
+func (t *ECEFTree) Query(coords []Coordinate, k int) ([]float64, []int, error) {
+	return t.QueryContext(context.Background(), coords, k)
+}
+
+// QueryContext 同 Query，每 ctxCheckInterval 个点检查一次 ctx 是否已取消
+func (t *ECEFTree) QueryContext(ctx context.Context, coords []Coordinate, k int) ([]float64, []int, error) {
+	if k < 1 {
+		return nil, nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	dists := make([]float64, len(coords)*k)
+	indices := make([]int, len(coords)*k)
+	for i, c := range coords {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		best := newBoundedHeap(k)
+		searchECEF(t.root, toECEF(c), best)
+		row := dists[i*k : (i+1)*k]
+		best.fill(row, indices[i*k:(i+1)*k])
+		for j := range row {
+			row[j] = math.Sqrt(row[j])
+		}
+	}
+	return dists, indices, nil
+}
+
+// searchECEF 递归k近邻搜索（堆中保存平方距离）
+func searchECEF(node *ecefNode, q [3]float64, best *boundedHeap) {
+	if node == nil {
+		return
+	}
+	best.offer(node.index, sqDist3(q, node.point))
+	diff := q[node.axis] - node.point[node.axis]
+	first, second := node.left, node.right
+	if diff >= 0 {
+		first, second = second, first
+	}
+	searchECEF(first, q, best)
+	if diff*diff < best.bound() {
+		searchECEF(second, q, best)
+	}
+}
+
+// ecefMargin 把大地坐标按球面近似时与椭球ECEF位置之间偏差的上界(km)，
+// 用来把球面半径换算成保守的弦长阈值
+const ecefMargin = 43.0
+
+// QueryRadius 返回球面距离不超过 radiusKm 的所有点（km，按距离升序），limit > 0 时截断。
+// 先用保守的弦长阈值在三维树中取候选，再按 haversine 精确过滤。
+func (t *ECEFTree) QueryRadius(c Coordinate, radiusKm float64, limit int) ([]float64, []int) {
+	theta := math.Min(radiusKm/EarthRadius, math.Pi)
+	chord := 2*WGS84MajorAxis*math.Sin(theta/2) + 2*ecefMargin
+	q := toECEF(c)
+	best := newBoundedHeap(radiusCapacity(limit))
+	var walk func(node *ecefNode)
+	walk = func(node *ecefNode) {
+		if node == nil {
+			return
+		}
+		if sqDist3(q, node.point) <= chord*chord {
+			p := t.coords[node.index]
+			if d := haversine(c.Lat, c.Lon, p.Lat, p.Lon); d <= radiusKm {
+				best.offer(node.index, d)
+			}
+		}
+		diff := q[node.axis] - node.point[node.axis]
+		if diff <= chord {
+			walk(node.left)
+		}
+		if -diff <= chord {
+			walk(node.right)
+		}
+	}
+	walk(t.root)
+	return best.split()
+}
+
+// QueryBBox 三维树没有经纬度分割轴，矩形检索退化为线性扫描
+func (t *ECEFTree) QueryBBox(minLat, minLon, maxLat, maxLon float64) []int {
+	return scanBBox(t.coords, minLat, minLon, maxLat, maxLon)
+}
+
+func sqDist3(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
@@ -0,0 +1,400 @@
+package rgeocoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// flatLeafSize 叶子桶容量，与 scipy cKDTree 的 leafsize 默认值一致
+const flatLeafSize = 30
+
+// flatNode 扁平数组中的节点；Axis < 0 表示叶子，叶子的点位于槽位 [Start, End)
+type flatNode struct {
+	Split       float64
+	Axis        int32
+	Left, Right int32
+	Start, End  int32
+}
+
+// FlatKDTree 数组存储的KD树：点按树序连续存放在扁平切片中，
+// 用 quickselect 划分（O(n log n) 构建），叶子使用桶，查询用显式栈代替递归。
+// Haversine/EuclideanDegrees 模式在经纬度二维上建树，ECEF 模式在三维上建树。
+type FlatKDTree struct {
+	mode  DistanceMode
+	dim   int
+	nodes []flatNode
+	pts   []float64 // 树序排列的坐标，步长 dim（二维时与 ll 共用）
+	ll    []float64 // 树序排列的 lat,lon
+	cos   []float64 // Haversine 模式下各槽位 cos(lat)，避免叶子内重复三角运算
+	index []int32   // 槽位 -> 原始索引
+}
+
+// NewFlatKDTree 构建扁平KD树
+func NewFlatKDTree(points []Coordinate, mode DistanceMode) *FlatKDTree {
+	if mode != DistanceEuclideanDegrees && mode != DistanceECEF {
+		mode = DistanceHaversine
+	}
+	t := &FlatKDTree{mode: mode, dim: 2}
+	if mode == DistanceECEF {
+		t.dim = 3
+	}
+	n := len(points)
+	src := make([]float64, 0, n*t.dim)
+	for _, c := range points {
+		if t.dim == 3 {
+			p := toECEF(c)
+			src = append(src, p[:]...)
+		} else {
+			src = append(src, c.Lat, c.Lon)
+		}
+	}
+	order := make([]int32, n)
+	for i := range order {
+		order[i] = int32(i)
+	}
+	if n > 0 {
+		b := flatBuilder{dim: t.dim, src: src, order: order, seed: 0x9e3779b97f4a7c15}
+		b.build()
+		t.nodes = b.nodes
+	}
+
+	// 按树序重排
+	t.index = order
+	t.ll = make([]float64, 2*n)
+	for slot, orig := range order {
+		t.ll[2*slot], t.ll[2*slot+1] = points[orig].Lat, points[orig].Lon
+	}
+	if t.dim == 3 {
+		t.pts = make([]float64, 3*n)
+		for slot, orig := range order {
+			copy(t.pts[3*slot:3*slot+3], src[3*int(orig):3*int(orig)+3])
+		}
+	} else {
+		t.pts = t.ll
+	}
+	if mode == DistanceHaversine {
+		t.cos = make([]float64, n)
+		for slot := range t.cos {
+			t.cos[slot] = math.Cos(t.ll[2*slot] * math.Pi / 180)
+		}
+	}
+	return t
+}
+
+// flatBuilder 构建期状态：只交换 order，不移动坐标
+type flatBuilder struct {
+	dim   int
+	src   []float64
+	order []int32
+	nodes []flatNode
+	seed  uint64
+}
+
+func (b *flatBuilder) key(slot, axis int) float64 {
+	return b.src[int(b.order[slot])*b.dim+axis]
+}
+
+func (b *flatBuilder) build() {
+	b.nodes = make([]flatNode, 1, 2*len(b.order)/flatLeafSize+1)
+	b.nodes[0] = flatNode{Start: 0, End: int32(len(b.order))}
+	stack := []int32{0}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		start, end := int(b.nodes[id].Start), int(b.nodes[id].End)
+		if end-start <= flatLeafSize {
+			b.nodes[id].Axis = -1
+			continue
+		}
+		axis := b.widestAxis(start, end)
+		mid := (start + end) / 2
+		b.selectNth(start, end, mid, axis)
+		left := int32(len(b.nodes))
+		b.nodes = append(b.nodes,
+			flatNode{Start: int32(start), End: int32(mid)},
+			flatNode{Start: int32(mid), End: int32(end)},
+		)
+		b.nodes[id].Axis = int32(axis)
+		b.nodes[id].Split = b.key(mid, axis)
+		b.nodes[id].Left, b.nodes[id].Right = left, left+1
+		stack = append(stack, left, left+1)
+	}
+}
+
+// widestAxis 选择跨度最大的维度
+func (b *flatBuilder) widestAxis(start, end int) int {
+	best, bestSpread := 0, -1.0
+	for axis := 0; axis < b.dim; axis++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for i := start; i < end; i++ {
+			v := b.key(i, axis)
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		if hi-lo > bestSpread {
+			best, bestSpread = axis, hi-lo
+		}
+	}
+	return best
+}
+
+// selectNth 三路划分的 quickselect：结束后 [lo,nth) <= order[nth] <= [nth,hi)
+func (b *flatBuilder) selectNth(lo, hi, nth, axis int) {
+	for hi-lo > 1 {
+		b.seed ^= b.seed << 13
+		b.seed ^= b.seed >> 7
+		b.seed ^= b.seed << 17
+		pivot := b.key(lo+int(b.seed%uint64(hi-lo)), axis)
+		lt, i, gt := lo, lo, hi
+		for i < gt {
+			switch v := b.key(i, axis); {
+			case v < pivot:
+				b.order[lt], b.order[i] = b.order[i], b.order[lt]
+				lt++
+				i++
+			case v > pivot:
+				gt--
+				b.order[i], b.order[gt] = b.order[gt], b.order[i]
+			default:
+				i++
+			}
+		}
+		switch {
+		case nth < lt:
+			hi = lt
+		case nth >= gt:
+			lo = gt
+		default:
+			return
+		}
+	}
+}
+
+// flatFrame 显式栈中的待访问节点与其区域到目标点的距离下界
+type flatFrame struct {
+	node  int32
+	bound float64
+	box   geoBox
+}
+
+// Query k近邻查询，距离单位同 KDTree/ECEFTree（平面为度，Haversine为km，ECEF为弦长km）
+func (t *FlatKDTree) Query(coords []Coordinate, k int) ([]float64, []int, error) {
+	return t.QueryContext(context.Background(), coords, k)
+}
+
+// QueryContext 同 Query，每 ctxCheckInterval 个点检查一次 ctx
+func (t *FlatKDTree) QueryContext(ctx context.Context, coords []Coordinate, k int) ([]float64, []int, error) {
+	if k < 1 {
+		return nil, nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	dists := make([]float64, len(coords)*k)
+	indices := make([]int, len(coords)*k)
+	best := newBoundedHeap(k)
+	stack := make([]flatFrame, 0, 64)
+	for i, c := range coords {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		best.items = best.items[:0]
+		stack = t.knn(c, best, stack)
+		row := dists[i*k : (i+1)*k]
+		best.fill(row, indices[i*k:(i+1)*k])
+		for j := range row {
+			if t.mode == DistanceHaversine {
+				row[j] = havToKm(row[j])
+			} else {
+				row[j] = math.Sqrt(row[j])
+			}
+		}
+	}
+	return dists, indices, nil
+}
+
+// knn 单点k近邻；堆中为平方距离（平面/ECEF）或半正矢值 hav（Haversine），两者都与距离单调
+func (t *FlatKDTree) knn(c Coordinate, best *boundedHeap, stack []flatFrame) []flatFrame {
+	if len(t.nodes) == 0 {
+		return stack
+	}
+	q := t.queryPoint(c)
+	spherical := t.mode == DistanceHaversine
+	cosQ := math.Cos(c.Lat * math.Pi / 180)
+	stack = append(stack[:0], flatFrame{node: 0, box: worldBox})
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.bound >= best.bound() {
+			continue
+		}
+		n := &t.nodes[f.node]
+		if n.Axis < 0 {
+			for slot := n.Start; slot < n.End; slot++ {
+				best.offer(int(t.index[slot]), t.slotDistance(q, cosQ, int(slot)))
+			}
+			continue
+		}
+		diff := q[n.Axis] - n.Split
+		near, far := n.Left, n.Right
+		if diff >= 0 {
+			near, far = far, near
+		}
+		var nearBox, farBox geoBox
+		var farBound float64
+		if spherical {
+			leftBox, rightBox := f.box.splitAxis(int(n.Axis), n.Split)
+			nearBox, farBox = leftBox, rightBox
+			if diff >= 0 {
+				nearBox, farBox = rightBox, leftBox
+			}
+			farBound = kmToHav(farBox.minDistance(c))
+		} else {
+			farBound = math.Max(f.bound, diff*diff)
+		}
+		if farBound < best.bound() {
+			stack = append(stack, flatFrame{node: far, bound: farBound, box: farBox})
+		}
+		stack = append(stack, flatFrame{node: near, bound: f.bound, box: nearBox})
+	}
+	return stack
+}
+
+// queryPoint 查询点在建树空间中的坐标
+func (t *FlatKDTree) queryPoint(c Coordinate) [3]float64 {
+	if t.dim == 3 {
+		return toECEF(c)
+	}
+	return [3]float64{c.Lat, c.Lon}
+}
+
+// slotDistance 查询点到槽位的比较用距离（平面/ECEF 为平方距离，Haversine 为 hav）
+func (t *FlatKDTree) slotDistance(q [3]float64, cosQ float64, slot int) float64 {
+	switch t.mode {
+	case DistanceHaversine:
+		sLat := math.Sin((t.ll[2*slot] - q[0]) * math.Pi / 360)
+		sLon := math.Sin((t.ll[2*slot+1] - q[1]) * math.Pi / 360)
+		return sLat*sLat + cosQ*t.cos[slot]*sLon*sLon
+	case DistanceECEF:
+		p := t.pts[3*slot : 3*slot+3]
+		dx, dy, dz := q[0]-p[0], q[1]-p[1], q[2]-p[2]
+		return dx*dx + dy*dy + dz*dz
+	default:
+		dlat, dlon := q[0]-t.pts[2*slot], q[1]-t.pts[2*slot+1]
+		return dlat*dlat + dlon*dlon
+	}
+}
+
+// havToKm 半正矢值转球面距离(km)
+func havToKm(h float64) float64 {
+	if math.IsInf(h, 1) {
+		return h
+	}
+	return 2 * EarthRadius * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+// kmToHav 球面距离(km)转半正矢值，超过半周长时取 1
+func kmToHav(km float64) float64 {
+	s := math.Sin(math.Min(km/(2*EarthRadius), math.Pi/2))
+	return s * s
+}
+
+// QueryRadius 返回球面距离不超过 radiusKm 的点（km，升序），limit > 0 时截断
+func (t *FlatKDTree) QueryRadius(c Coordinate, radiusKm float64, limit int) ([]float64, []int) {
+	best := newBoundedHeap(radiusCapacity(limit))
+	if len(t.nodes) == 0 {
+		return best.split()
+	}
+	bound := func() float64 { return math.Min(radiusKm, best.bound()) }
+	offerLeaf := func(n *flatNode) {
+		for slot := n.Start; slot < n.End; slot++ {
+			if d := haversine(c.Lat, c.Lon, t.ll[2*slot], t.ll[2*slot+1]); d <= radiusKm {
+				best.offer(int(t.index[slot]), d)
+			}
+		}
+	}
+	if t.dim == 3 {
+		// 与 ECEFTree 相同的保守弦长阈值
+		theta := math.Min(radiusKm/EarthRadius, math.Pi)
+		chord := 2*WGS84MajorAxis*math.Sin(theta/2) + 2*ecefMargin
+		q := toECEF(c)
+		stack := []int32{0}
+		for len(stack) > 0 {
+			n := &t.nodes[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			if n.Axis < 0 {
+				offerLeaf(n)
+				continue
+			}
+			diff := q[n.Axis] - n.Split
+			if diff <= chord {
+				stack = append(stack, n.Left)
+			}
+			if -diff <= chord {
+				stack = append(stack, n.Right)
+			}
+		}
+		return best.split()
+	}
+	stack := []flatFrame{{node: 0, box: worldBox}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.box.minDistance(c) > bound() {
+			continue
+		}
+		n := &t.nodes[f.node]
+		if n.Axis < 0 {
+			offerLeaf(n)
+			continue
+		}
+		leftBox, rightBox := f.box.splitAxis(int(n.Axis), n.Split)
+		stack = append(stack, flatFrame{node: n.Left, box: leftBox}, flatFrame{node: n.Right, box: rightBox})
+	}
+	return best.split()
+}
+
+// QueryBBox 返回经纬度矩形内的点索引（升序）；ECEF 模式退化为线性扫描
+func (t *FlatKDTree) QueryBBox(minLat, minLon, maxLat, maxLon float64) []int {
+	var out []int
+	parts := bboxParts(minLat, minLon, maxLat, maxLon)
+	if t.dim == 3 || len(t.nodes) == 0 {
+		for slot := range t.index {
+			c := Coordinate{Lat: t.ll[2*slot], Lon: t.ll[2*slot+1]}
+			for _, b := range parts {
+				if b.contains(c) {
+					out = append(out, int(t.index[slot]))
+					break
+				}
+			}
+		}
+	} else {
+		for _, b := range parts {
+			stack := []int32{0}
+			for len(stack) > 0 {
+				n := &t.nodes[stack[len(stack)-1]]
+				stack = stack[:len(stack)-1]
+				if n.Axis < 0 {
+					for slot := n.Start; slot < n.End; slot++ {
+						if b.contains(Coordinate{Lat: t.ll[2*slot], Lon: t.ll[2*slot+1]}) {
+							out = append(out, int(t.index[slot]))
+						}
+					}
+					continue
+				}
+				lo, hi := b.minLon, b.maxLon
+				if n.Axis == 0 {
+					lo, hi = b.minLat, b.maxLat
+				}
+				if lo <= n.Split {
+					stack = append(stack, n.Left)
+				}
+				if hi >= n.Split {
+					stack = append(stack, n.Right)
+				}
+			}
+		}
+	}
+	sort.Ints(out)
+	return out
+}
@@ -2,75 +2,167 @@ package rgeocoder
 
 import (
 	"context"
+	"runtime"
 	"sync"
 )
 
-// KDTreeMP 并发包装（当前直接调用单线程实现）
+const (
+	// mpMinChunk 每个分块的最小点数，小于它的批次直接在调用方协程里查询
+	mpMinChunk = 256
+	// mpChunksPerWorker 每个worker平均分到的块数，用于平衡负载
+	mpChunksPerWorker = 4
+)
+
+// KDTreeMP 多线程KD树：批量坐标切成连续分块，交给常驻worker池并行查询。
+// worker 在实例生命周期内复用，Close 后退化为调用方协程内的顺序查询。
 type KDTreeMP struct {
-	base    *KDTree
+	base    baseTree
 	workers int
+	pool    *mpPool
 }
 
-// NewKDTreeMP 创建多线程版本（占位）
+// mpPool 常驻worker池（单独成结构体，worker 不持有 KDTreeMP，便于终结器回收）
+type mpPool struct {
+	mu     sync.RWMutex
+	closed bool
+	tasks  chan mpTask
+}
+
+// mpTask 一个连续分块：查询 coords，结果写入 dists/indices 对应区间
+type mpTask struct {
+	ctx     context.Context
+	base    baseTree
+	coords  []Coordinate
+	k       int
+	dists   []float64
+	indices []int
+	done    func(error)
+}
+
+// NewKDTreeMP 创建多线程版本，workers <= 0 时使用 GOMAXPROCS
 func NewKDTreeMP(points []Coordinate, workers int, mode DistanceMode) *KDTreeMP {
+	return newKDTreeMP(newBaseTree(points, mode), workers)
+}
+
+// newKDTreeMP 包装任意单线程实现
+func newKDTreeMP(base baseTree, workers int) *KDTreeMP {
 	if workers <= 0 {
-		workers = 4
+		workers = runtime.GOMAXPROCS(0)
+	}
+	t := &KDTreeMP{base: base, workers: workers}
+	t.pool = &mpPool{tasks: make(chan mpTask, workers)}
+	for w := 0; w < workers; w++ {
+		go t.pool.work()
 	}
-	return &KDTreeMP{base: NewKDTree(points, mode), workers: workers}
+	// 忘记 Close 时也不泄漏worker
+	runtime.SetFinalizer(t, (*KDTreeMP).Close)
+	return t
 }
 
-// Query 并发查询（暂时顺序调用）
-func (t *KDTreeMP) Query(coords []Coordinate, k int) ([]float64, []int, error) {
-	// 简单拆分任务，但由于base是线性扫描，收益有限
-	if len(coords) < 2 || t.workers <= 1 {
-		return t.base.Query(coords, k)
+func (p *mpPool) work() {
+	for task := range p.tasks {
+		task.run()
+	}
+}
+
+func (task mpTask) run() {
+	// 已取消的批次剩余分块直接跳过
+	if err := task.ctx.Err(); err != nil {
+		task.done(err)
+		return
 	}
+	ds, inds, err := task.base.QueryContext(task.ctx, task.coords, task.k)
+	if err == nil {
+		copy(task.dists, ds)
+		copy(task.indices, inds)
+	}
+	task.done(err)
+}
+
+// Query 并行k近邻查询，结果布局与距离单位同底层实现
+func (t *KDTreeMP) Query(coords []Coordinate, k int) ([]float64, []int, error) {
+	return t.QueryContext(context.Background(), coords, k)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// QueryContext 同 Query；ctx 取消后不再派发新分块，已派发的分块在块内定期检查
+func (t *KDTreeMP) QueryContext(ctx context.Context, coords []Coordinate, k int) ([]float64, []int, error) {
+	chunk := t.chunkSize(len(coords))
+	if len(coords) <= chunk {
+		return t.base.QueryContext(ctx, coords, k)
+	}
 
-	type part struct {
-		idx   int
-		coord Coordinate
+	t.pool.mu.RLock()
+	if t.pool.closed {
+		t.pool.mu.RUnlock()
+		return t.base.QueryContext(ctx, coords, k)
 	}
-	jobs := make(chan part)
+	dists := make([]float64, len(coords)*k)
+	indices := make([]int, len(coords)*k)
 	var wg sync.WaitGroup
-	results := make([]struct {
-		d float64
-		i int
-	}, len(coords))
-
-	worker := func() {
-		defer wg.Done()
-		for p := range jobs {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			_, inds, _ := t.base.Query([]Coordinate{p.coord}, 1)
-			results[p.idx] = struct {
-				d float64
-				i int
-			}{d: 0, i: inds[0]}
+	var errOnce sync.Once
+	var firstErr error
+	setErr := func(err error) { errOnce.Do(func() { firstErr = err }) }
+	done := func(err error) {
+		if err != nil {
+			setErr(err)
 		}
+		wg.Done()
 	}
-
-	wg.Add(t.workers)
-	for w := 0; w < t.workers; w++ {
-		go worker()
-	}
-	for i, c := range coords {
-		jobs <- part{idx: i, coord: c}
+dispatch:
+	for start := 0; start < len(coords); start += chunk {
+		end := min(start+chunk, len(coords))
+		task := mpTask{
+			ctx:     ctx,
+			base:    t.base,
+			coords:  coords[start:end],
+			k:       k,
+			dists:   dists[start*k : end*k],
+			indices: indices[start*k : end*k],
+			done:    done,
+		}
+		wg.Add(1)
+		select {
+		case t.pool.tasks <- task:
+		case <-ctx.Done():
+			wg.Done()
+			setErr(ctx.Err())
+			break dispatch
+		}
 	}
-	close(jobs)
+	t.pool.mu.RUnlock()
 	wg.Wait()
-
-	dists := make([]float64, len(coords))
-	indices := make([]int, len(coords))
-	for i, r := range results {
-		dists[i] = r.d
-		indices[i] = r.i
+	if firstErr != nil {
+		return nil, nil, firstErr
 	}
 	return dists, indices, nil
 }
+
+// chunkSize 按worker数切分，保证每块不小于 mpMinChunk
+func (t *KDTreeMP) chunkSize(n int) int {
+	if t.workers <= 1 {
+		return n
+	}
+	size := (n + t.workers*mpChunksPerWorker - 1) / (t.workers * mpChunksPerWorker)
+	return max(size, mpMinChunk)
+}
+
+// Close 停止worker池，可重复调用
+func (t *KDTreeMP) Close() error {
+	t.pool.mu.Lock()
+	defer t.pool.mu.Unlock()
+	if !t.pool.closed {
+		t.pool.closed = true
+		close(t.pool.tasks)
+	}
+	return nil
+}
+
+// QueryRadius 半径检索直接委托给单线程实现
+func (t *KDTreeMP) QueryRadius(c Coordinate, radiusKm float64, limit int) ([]float64, []int) {
+	return t.base.QueryRadius(c, radiusKm, limit)
+}
+
+// QueryBBox 矩形检索直接委托给单线程实现
+func (t *KDTreeMP) QueryBBox(minLat, minLon, maxLat, maxLon float64) []int {
+	return t.base.QueryBBox(minLat, minLon, maxLat, maxLon)
+}
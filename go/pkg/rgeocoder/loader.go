@@ -2,26 +2,38 @@ package rgeocoder
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 // DataLoader 负责加载数据
 type DataLoader struct {
 	config  *Config
 	verbose bool
+	report  *LoadReport
+	source  snapshotSource // LoadFromFile 读取的文件大小与哈希
 }
 
 func NewDataLoader(cfg *Config) *DataLoader { return &DataLoader{config: cfg, verbose: cfg.Verbose} }
 
-var expectedHeader = []string{"lat", "lon", "name", "admin1", "admin2", "cc"}
+// expectedHeader 处理后数据集的表头。前 legacyColumns 列与 Python 版本一致，只有这几列的旧数据集同样可以加载。
+var expectedHeader = []string{"lat", "lon", "name", "admin1", "admin2", "cc",
+	"geonameid", "population", "feature_class", "feature_code", "timezone", "elevation", "admin1_code", "admin2_code"}
 
-// LoadFromFile 读取 rg_cities1000.csv (未实现)
+const (
+	legacyColumns = 6
+	colGeoNameID  = 6
+)
+
+// LoadFromFile 读取处理后的数据集（可为 gzip / zstd / zip 压缩）
 func (dl *DataLoader) LoadFromFile(filename string) ([]Coordinate, []Location, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -31,30 +43,75 @@ func (dl *DataLoader) LoadFromFile(filename string) ([]Coordinate, []Location, e
 	if dl.verbose {
 		fmt.Println("loading dataset:", filename)
 	}
-	return dl.parseCSV(file)
+	// 边读边计算哈希，供 SaveSnapshot 记录快照对应的数据集
+	h := sha256.New()
+	coords, locs, err := dl.LoadFromStream(io.TeeReader(file, h))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, nil, err
+	}
+	if fi, err := file.Stat(); err == nil {
+		dl.source.Size = uint64(fi.Size())
+		dl.source.ModTime = fi.ModTime().UnixNano()
+		copy(dl.source.Sum[:], h.Sum(nil))
+	}
+	return coords, locs, nil
 }
 
-// LoadFromStream 从自定义流读取
+// LoadFromFS 从任意 fs.FS（embed.FS、zip.Reader 等）读取数据集，压缩格式按魔数识别
+func (dl *DataLoader) LoadFromFS(fsys fs.FS, name string) ([]Coordinate, []Location, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if dl.verbose {
+		fmt.Println("loading dataset from fs:", name)
+	}
+	return dl.LoadFromStream(file)
+}
+
+// LoadFromStream 从自定义流读取，压缩格式按魔数识别
 func (dl *DataLoader) LoadFromStream(r io.Reader) ([]Coordinate, []Location, error) {
-	return dl.parseCSV(r)
+	rc, err := openDecompressed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	return dl.parseCSV(rc)
 }
 
-// ExtractAndProcess 下载+处理 GeoNames (未实现)
+// ExtractAndProcess 下载（如需要）并处理 GeoNames 原始数据，生成 Config.Dataset 对应的 CSV 后加载
 func (dl *DataLoader) ExtractAndProcess() ([]Coordinate, []Location, error) {
-	// 未来: 下载 + 生成 rg_cities1000.csv
-	return nil, nil, errors.New("ExtractAndProcess not implemented")
+	if dl.config.AutoDownload {
+		if err := NewDownloader(dl.config).DownloadRequired(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := NewDataProcessor(dl.config).ProcessGeoNamesData(); err != nil {
+		return nil, nil, err
+	}
+	return dl.LoadFromFile(datasetPath(dl.config.DataDir, dl.config.dataset()))
 }
 
-// parseCSV 通用解析
+// parseCSV 通用解析。严格模式遇到第一条坏行即返回 *RowError；
+// 宽松模式跳过坏行，并把统计写入 dl.Report()。
 func (dl *DataLoader) parseCSV(r io.Reader) ([]Coordinate, []Location, error) {
+	dl.report = nil
 	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1 // 列数由下面逐行检查，以便报告行号
 	head, err := reader.Read()
 	if err != nil {
 		return nil, nil, fmt.Errorf("read header: %w", err)
 	}
-	if err := dl.validateHeader(head); err != nil {
+	cols, err := dl.resolveColumns(head)
+	if err != nil {
 		return nil, nil, err
 	}
+	report := newLoadReport()
+	seen := make(rowSet)
 	coords := make([]Coordinate, 0, 1024)
 	locs := make([]Location, 0, 1024)
 	for {
@@ -62,34 +119,183 @@ func (dl *DataLoader) parseCSV(r io.Reader) ([]Coordinate, []Location, error) {
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
+		var row RejectedRow
+		var c Coordinate
+		var loc Location
+		var pe *csv.ParseError
+		switch {
+		case errors.As(err, &pe):
+			row = RejectedRow{Line: pe.StartLine, Reason: RejectMalformed, Detail: pe.Err.Error()}
+		case err != nil:
 			return nil, nil, fmt.Errorf("read record: %w", err)
+		case len(rec) != len(cols.head):
+			row.Reason, row.Detail = RejectFieldCount, fmt.Sprintf("%d fields, want %d", len(rec), len(cols.head))
+		default:
+			c, row.Reason, row.Detail = parseLatLon(rec[cols.lat], rec[cols.lon])
+			if row.Reason == "" {
+				loc, row.Reason, row.Detail = cols.location(rec)
+			}
+			if row.Reason == "" && !seen.add(rec) {
+				row.Reason, row.Detail = RejectDuplicate, "same as an earlier row"
+			}
 		}
-		if len(rec) < 6 {
+		report.Rows++
+		if row.Reason != "" {
+			if row.Line == 0 {
+				row.Line, _ = reader.FieldPos(0)
+				row.Record = rec
+			}
+			if dl.config.StrictLoad {
+				return nil, nil, &RowError{row}
+			}
+			report.reject(row)
 			continue
 		}
-		lat, err1 := strconv.ParseFloat(rec[0], 64)
-		lon, err2 := strconv.ParseFloat(rec[1], 64)
-		if err1 != nil || err2 != nil {
-			continue
-		}
-		coords = append(coords, Coordinate{Lat: lat, Lon: lon})
-		locs = append(locs, Location{Lat: rec[0], Lon: rec[1], Name: rec[2], Admin1: rec[3], Admin2: rec[4], CC: rec[5]})
+		coords = append(coords, c)
+		locs = append(locs, loc)
+	}
+	report.Loaded = len(locs)
+	dl.report = report
+	if dl.verbose && report.Rejected > 0 {
+		fmt.Println("load report:", report)
 	}
 	return coords, locs, nil
 }
 
+// Report 最近一次 CSV 加载的统计，加载失败时为 nil
+func (dl *DataLoader) Report() *LoadReport { return dl.report }
+
+// csvColumns 表头解析结果：各字段所在列（-1 表示不读取）与进入 Attributes 的列
+type csvColumns struct {
+	lat, lon, name, admin1, admin2, cc int
+
+	geonameID, population, featureClass, featureCode, timezone, elevation, admin1Code, admin2Code int
+
+	extra []int
+	head  []string
+}
+
+// resolveColumns 未配置映射时要求标准表头（六列或完整列），否则按列名定位
+func (dl *DataLoader) resolveColumns(head []string) (*csvColumns, error) {
+	m := dl.config.Columns
+	if m == nil {
+		if err := dl.validateHeader(head); err != nil {
+			return nil, err
+		}
+		cols := &csvColumns{lat: 0, lon: 1, name: 2, admin1: 3, admin2: 4, cc: 5, head: head}
+		if len(head) == legacyColumns {
+			cols.geonameID, cols.population, cols.featureClass, cols.featureCode = -1, -1, -1, -1
+			cols.timezone, cols.elevation, cols.admin1Code, cols.admin2Code = -1, -1, -1, -1
+			return cols, nil
+		}
+		cols.geonameID, cols.population, cols.featureClass, cols.featureCode = 6, 7, 8, 9
+		cols.timezone, cols.elevation, cols.admin1Code, cols.admin2Code = 10, 11, 12, 13
+		return cols, nil
+	}
+	if m.Lat == "" || m.Lon == "" {
+		return nil, errors.New("column mapping: lat and lon columns are required")
+	}
+	head[0] = strings.TrimPrefix(head[0], "\ufeff") // Excel 导出的 UTF-8 BOM
+	pos := make(map[string]int, len(head))
+	for i, h := range head {
+		if _, dup := pos[h]; !dup {
+			pos[h] = i
+		}
+	}
+	used := make([]bool, len(head))
+	find := func(name string, dst *int) error {
+		*dst = -1
+		if name == "" {
+			return nil
+		}
+		i, ok := pos[name]
+		if !ok {
+			return fmt.Errorf("column mapping: column %q not found in header", name)
+		}
+		*dst, used[i] = i, true
+		return nil
+	}
+	cols := &csvColumns{head: head}
+	for _, f := range []struct {
+		name string
+		dst  *int
+	}{
+		{m.Lat, &cols.lat}, {m.Lon, &cols.lon}, {m.Name, &cols.name},
+		{m.Admin1, &cols.admin1}, {m.Admin2, &cols.admin2}, {m.CC, &cols.cc},
+		{m.GeoNameID, &cols.geonameID}, {m.Population, &cols.population},
+		{m.FeatureClass, &cols.featureClass}, {m.FeatureCode, &cols.featureCode},
+		{m.Timezone, &cols.timezone}, {m.Elevation, &cols.elevation},
+		{m.Admin1Code, &cols.admin1Code}, {m.Admin2Code, &cols.admin2Code},
+	} {
+		if err := find(f.name, f.dst); err != nil {
+			return nil, err
+		}
+	}
+	for i := range head {
+		if !used[i] {
+			cols.extra = append(cols.extra, i)
+		}
+	}
+	return cols, nil
+}
+
+// location 按列生成 Location；整数列（geonameid、population、elevation）为空时取 0，无法解析时拒绝该行
+func (c *csvColumns) location(rec []string) (Location, RejectReason, string) {
+	field := func(i int) string {
+		if i < 0 {
+			return ""
+		}
+		return rec[i]
+	}
+	loc := Location{
+		Lat:          rec[c.lat],
+		Lon:          rec[c.lon],
+		Name:         field(c.name),
+		Admin1:       field(c.admin1),
+		Admin2:       field(c.admin2),
+		CC:           field(c.cc),
+		FeatureClass: field(c.featureClass),
+		FeatureCode:  field(c.featureCode),
+		Timezone:     field(c.timezone),
+		Admin1Code:   field(c.admin1Code),
+		Admin2Code:   field(c.admin2Code),
+	}
+	for _, f := range []struct {
+		col int
+		dst *int
+	}{
+		{c.geonameID, &loc.GeoNameID}, {c.population, &loc.Population}, {c.elevation, &loc.Elevation},
+	} {
+		if s := field(f.col); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return loc, RejectBadInt, fmt.Sprintf("%s: %q", c.head[f.col], s)
+			}
+			*f.dst = v
+		}
+	}
+	if len(c.extra) > 0 {
+		loc.Attributes = make(map[string]string, len(c.extra))
+		for _, i := range c.extra {
+			loc.Attributes[c.head[i]] = rec[i]
+		}
+	}
+	return loc, "", ""
+}
+
 func (dl *DataLoader) validateHeader(head []string) error {
-	if len(head) != len(expectedHeader) {
+	if len(head) != legacyColumns && len(head) != len(expectedHeader) {
 		return fmt.Errorf("unexpected header column count: %d", len(head))
 	}
-	for i, col := range expectedHeader {
-		if head[i] != col {
-			return fmt.Errorf("invalid header at %d: got %s want %s", i, head[i], col)
+	for i, col := range head {
+		if col != expectedHeader[i] {
+			return fmt.Errorf("invalid header at %d: got %s want %s", i, col, expectedHeader[i])
 		}
 	}
 	return nil
 }
 
-// Helper to get default dataset path
-func datasetPath(dataDir string) string { return filepath.Join(dataDir, "rg_cities1000.csv") }
+// datasetPath 处理后的数据集路径：rg_<Name>.csv（cities1000 与 Python 版本一致）
+func datasetPath(dataDir string, ds Dataset) string {
+	return filepath.Join(dataDir, "rg_"+ds.Name+".csv")
+}
@@ -0,0 +1,162 @@
+package rgeocoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// 快照中的节点布局必须与内存中的 flatNode 一致才能直接映射
+var (
+	_ [flatNodeSize - unsafe.Sizeof(flatNode{})]byte
+	_ [unsafe.Sizeof(flatNode{}) - flatNodeSize]byte
+)
+
+// MappedIndex 基于 mmap 的只读索引与位置存储，文件即 SaveSnapshot 写出的快照。
+// 查询直接读取映射内存而不反序列化，多个进程映射同一文件时由操作系统页缓存共享一份数据。
+// Close 会解除映射，之后不得再查询。
+type MappedIndex struct {
+	*FlatKDTree
+	coords []Coordinate
+	offs   []uint32
+	blob   []byte
+	source snapshotSource
+
+	data      []byte
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OpenMappedIndex 映射快照文件。只校验文件头和结构（节点、索引、字符串偏移），
+// 不计算整个文件的 checksum，需要完整校验时用 LoadSnapshot。
+func OpenMappedIndex(path string) (*MappedIndex, error) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		return nil, errors.New("mapped index requires a little-endian host")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < snapshotHeaderSize {
+		return nil, fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+	data, err := mmapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	m, err := newMappedIndex(data)
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMappedIndex(data []byte) (*MappedIndex, error) {
+	h, err := parseSnapshotHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	l := h.layout()
+	n := int(h.Count)
+	if int64(len(data)) < l.blob {
+		return nil, fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+	t := &FlatKDTree{
+		mode:  h.Mode,
+		dim:   2,
+		nodes: mapSlice[flatNode](data, l.nodes, int(h.Nodes)),
+		ll:    mapSlice[float64](data, l.ll, 2*n),
+		index: mapSlice[int32](data, l.index, n),
+	}
+	t.pts = t.ll
+	if l.pts >= 0 {
+		t.dim = 3
+		t.pts = mapSlice[float64](data, l.pts, 3*n)
+	}
+	if l.cos >= 0 {
+		t.cos = mapSlice[float64](data, l.cos, n)
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	m := &MappedIndex{
+		FlatKDTree: t,
+		coords:     mapSlice[Coordinate](data, l.coords, n),
+		offs:       mapSlice[uint32](data, l.strOff, snapshotFields*n+1),
+		source:     h.Source,
+		data:       data,
+	}
+	for i := 1; i < len(m.offs); i++ {
+		if m.offs[i] < m.offs[i-1] {
+			return nil, fmt.Errorf("%w: bad string offset", ErrBadSnapshot)
+		}
+	}
+	if int64(len(data)) != l.blob+int64(m.offs[len(m.offs)-1]) {
+		return nil, fmt.Errorf("%w: string table size mismatch", ErrBadSnapshot)
+	}
+	m.blob = data[l.blob:]
+	return m, nil
+}
+
+// mapSlice 把 data[off:] 视为 n 个 T（各段 8 字节对齐，映射起点按页对齐）
+func mapSlice[T any](data []byte, off int64, n int) []T {
+	if n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(&data[off])), n)
+}
+
+// Mode 快照建树时使用的距离模式
+func (m *MappedIndex) Mode() DistanceMode { return m.mode }
+
+// Len 位置数量
+func (m *MappedIndex) Len() int { return len(m.coords) }
+
+// Coordinate 第 i 个位置的坐标
+func (m *MappedIndex) Coordinate(i int) Coordinate { return m.coords[i] }
+
+// At 第 i 个位置；字符串从映射内存复制，Close 后仍然有效
+func (m *MappedIndex) At(i int) Location {
+	k := snapshotFields
+	f := make([]string, k)
+	for j := range f {
+		f[j] = string(m.blob[m.offs[k*i+j]:m.offs[k*i+j+1]])
+	}
+	return locationFromFields(f)
+}
+
+// Close 解除映射，可重复调用
+func (m *MappedIndex) Close() error {
+	m.closeOnce.Do(func() { m.closeErr = munmap(m.data) })
+	return m.closeErr
+}
+
+// OpenMapped 以 mmap 方式打开快照创建实例；KD树与位置数据都直接读取映射内存。
+// 距离模式取快照中记录的值，其余选项照常生效。
+func OpenMapped(path string, opts ...Option) (*RGeocoder, error) {
+	return openMapped(path, applyOptions(opts))
+}
+
+func openMapped(path string, cfg *Config) (*RGeocoder, error) {
+	m, err := OpenMappedIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DistanceMode = m.mode
+	cfg.TreeLayout = TreeFlat
+	if cfg.Verbose {
+		fmt.Printf("mapped %d locations from %s\n", m.Len(), path)
+	}
+	rg := newGeocoder(cfg, m.coords, m, wrapTree(m, cfg))
+	rg.cur.Load().source = m.source
+	return rg, nil
+}
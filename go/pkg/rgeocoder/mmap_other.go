@@ -0,0 +1,19 @@
+//go:build !unix
+
+package rgeocoder
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile 不支持 mmap 的平台退化为整体读入内存
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func munmap([]byte) error { return nil }
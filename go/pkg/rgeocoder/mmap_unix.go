@@ -0,0 +1,15 @@
+//go:build unix
+
+package rgeocoder
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile 只读共享映射整个文件
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error { return syscall.Munmap(b) }
@@ -1,13 +1,349 @@
 package rgeocoder
 
-// DataProcessor 处理原始GeoNames数据 -> rg_cities1000.csv（占位）
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GeoNames cities 文件列（与Python版本 GN_COLUMNS 一致）
+const (
+	gnGeoNameID        = 0
+	gnASCIIName        = 2
+	gnLatitude         = 4
+	gnLongitude        = 5
+	gnFeatureClass     = 6
+	gnFeatureCode      = 7
+	gnCountryCode      = 8
+	gnAdmin1Code       = 10
+	gnAdmin2Code       = 11
+	gnPopulation       = 14
+	gnElevation        = 15
+	gnDEM              = 16
+	gnTimezone         = 17
+	gnModificationDate = 18
+	gnColumnCount      = 19
+)
+
+// GeoNames admin 编码文件列（与Python版本 ADMIN_COLUMNS 一致）
+const (
+	adminConcatCodes = 0
+	adminName        = 1
+	adminASCIIName   = 2
+	adminGeoNameID   = 3
+)
+
+// DataProcessor 处理原始GeoNames数据 -> rg_<Dataset.Name>.csv
 type DataProcessor struct {
 	config *Config
+	report *LoadReport
 }
 
 func NewDataProcessor(cfg *Config) *DataProcessor { return &DataProcessor{config: cfg} }
 
+// ProcessGeoNamesData 读取 DataDir 中 Config.Dataset 的 zip 文件与 admin 编码文件，生成 rg_<Name>.csv，
+// 以及应用每日增量所需的增量状态（见 ApplyDiff）。无法解析的行跳过并计入 Report；
+// Config.StrictLoad 时遇到第一条即失败（*RowError 带行号）。
 func (p *DataProcessor) ProcessGeoNamesData() error {
-	// TODO: 实现数据转换逻辑
+	urls := p.config.DownloadURLs
+	dir := p.config.DataDir
+	ds := p.config.dataset()
+
+	if p.config.Verbose {
+		fmt.Println("loading admin1 codes...")
+	}
+	admin1, err := loadAdminFile(filepath.Join(dir, urls.Admin1Codes))
+	if err != nil {
+		return err
+	}
+	if p.config.Verbose {
+		fmt.Println("loading admin2 codes...")
+	}
+	admin2, err := loadAdminFile(filepath.Join(dir, urls.Admin2Codes))
+	if err != nil {
+		return err
+	}
+
+	if p.config.Verbose {
+		fmt.Println("creating formatted geocoded file...")
+	}
+	cv := &converter{ds: ds, admin1: admin1, admin2: admin2, strict: p.config.StrictLoad, report: newLoadReport()}
+	err = writeFileAtomic(datasetPath(dir, ds), func(w io.Writer) error {
+		cv.cw = csv.NewWriter(w)
+		if err := cv.cw.Write(expectedHeader); err != nil {
+			return err
+		}
+		for _, name := range ds.Files {
+			if err := cv.convertZip(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		cv.cw.Flush()
+		return cv.cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cv.report.Loaded = cv.rows
+	p.report = cv.report
+	if p.config.Verbose {
+		fmt.Printf("wrote %d locations\n", cv.rows)
+		if cv.report.Rejected > 0 {
+			fmt.Println("process report:", cv.report)
+		}
+	}
+	// 全量数据已包含最新修改日期及之前的全部修改，之后的增量从下一天开始
+	state := &DiffState{}
+	if !cv.latest.IsZero() {
+		state.LastApplied = cv.latest.Format(diffDateLayout)
+	}
+	return saveDiffState(dir, ds, state)
+}
+
+// Report 最近一次 ProcessGeoNamesData 的统计：Rows 为 GeoNames 中的数据行数，Loaded 为写入数据集的行数
+// （按数据集条件筛掉的记录不计为拒绝），Rejected 为无法解析而跳过的行。尚未成功处理时为 nil。
+func (p *DataProcessor) Report() *LoadReport { return p.report }
+
+// converter 把 GeoNames 记录关联 admin 名称后写成数据集行，记录行数与最新的修改日期
+type converter struct {
+	ds             Dataset
+	admin1, admin2 map[string]AdminRecord
+	cw             *csv.Writer
+	rows           int
+	latest         time.Time
+	strict         bool
+	report         *LoadReport
+}
+
+// convertZip 转换 zip 中与其同名的 .txt（cities1000.zip -> cities1000.txt）
+func (cv *converter) convertZip(zipPath string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", zipPath, err)
+	}
+	defer zr.Close()
+	entry := strings.TrimSuffix(filepath.Base(zipPath), ".zip") + ".txt"
+	var cities *zip.File
+	for _, f := range zr.File {
+		if f.Name == entry {
+			cities = f
+			break
+		}
+	}
+	if cities == nil {
+		return fmt.Errorf("%s not found in %s", entry, zipPath)
+	}
+	rc, err := cities.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := cv.convert(rc); err != nil {
+		return fmt.Errorf("%s: %w", entry, err)
+	}
 	return nil
 }
+
+// convert 逐行读取 GeoNames 文件；数据集要求筛选时跳过不满足收录条件的记录，无法解析的行计入 report
+func (cv *converter) convert(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		cv.report.Rows++
+		rec, reason, detail := parseGeoNamesRecord(fields)
+		if reason != "" {
+			row := RejectedRow{Line: line, Reason: reason, Detail: detail, Record: fields}
+			if cv.strict {
+				return &RowError{row}
+			}
+			cv.report.reject(row)
+			continue
+		}
+		if rec.ModificationDate.After(cv.latest) {
+			cv.latest = rec.ModificationDate
+		}
+		if cv.ds.Filter && !cv.ds.keep(rec) {
+			continue
+		}
+		if err := cv.cw.Write(rec.toRow(cv.admin1, cv.admin2)); err != nil {
+			return err
+		}
+		cv.rows++
+	}
+	return scanner.Err()
+}
+
+// ParseGeoNamesRecord 解析 GeoNames cities 文件中的一行（已按制表符拆分）
+func ParseGeoNamesRecord(fields []string) (GeoNamesRecord, error) {
+	rec, reason, detail := parseGeoNamesRecord(fields)
+	if reason != "" {
+		return GeoNamesRecord{}, errors.New(detail)
+	}
+	return rec, nil
+}
+
+// parseGeoNamesRecord 同 ParseGeoNamesRecord，失败时返回拒绝原因与说明
+func parseGeoNamesRecord(fields []string) (GeoNamesRecord, RejectReason, string) {
+	if len(fields) < gnColumnCount {
+		return GeoNamesRecord{}, RejectFieldCount, fmt.Sprintf("expected %d columns, got %d", gnColumnCount, len(fields))
+	}
+	id, err := strconv.Atoi(fields[gnGeoNameID])
+	if err != nil {
+		return GeoNamesRecord{}, RejectBadInt, "geonameid: " + err.Error()
+	}
+	lat, err := strconv.ParseFloat(fields[gnLatitude], 64)
+	if err != nil {
+		return GeoNamesRecord{}, RejectBadFloat, "latitude: " + err.Error()
+	}
+	lon, err := strconv.ParseFloat(fields[gnLongitude], 64)
+	if err != nil {
+		return GeoNamesRecord{}, RejectBadFloat, "longitude: " + err.Error()
+	}
+	rec := GeoNamesRecord{
+		GeoNameID:    id,
+		ASCIIName:    fields[gnASCIIName],
+		Latitude:     lat,
+		Longitude:    lon,
+		FeatureClass: fields[gnFeatureClass],
+		FeatureCode:  fields[gnFeatureCode],
+		CountryCode:  fields[gnCountryCode],
+		Admin1Code:   fields[gnAdmin1Code],
+		Admin2Code:   fields[gnAdmin2Code],
+		Timezone:     fields[gnTimezone],
+		latText:      fields[gnLatitude],
+		lonText:      fields[gnLongitude],
+	}
+	if s := fields[gnPopulation]; s != "" {
+		if rec.Population, err = strconv.Atoi(s); err != nil {
+			return GeoNamesRecord{}, RejectBadInt, "population: " + err.Error()
+		}
+	}
+	elevation := fields[gnElevation]
+	if elevation == "" && fields[gnDEM] != "-9999" { // -9999 表示 DEM 无数据（海上）
+		elevation = fields[gnDEM]
+	}
+	if elevation != "" {
+		if rec.Elevation, err = strconv.Atoi(elevation); err != nil {
+			return GeoNamesRecord{}, RejectBadInt, "elevation: " + err.Error()
+		}
+	}
+	if s := fields[gnModificationDate]; s != "" {
+		if rec.ModificationDate, err = time.Parse("2006-01-02", s); err != nil {
+			return GeoNamesRecord{}, RejectBadDate, "modification date: " + err.Error()
+		}
+	}
+	return rec, "", ""
+}
+
+// toRow 按 expectedHeader 生成一行；经纬度优先使用解析时的原始文本
+func (r GeoNamesRecord) toRow(admin1, admin2 map[string]AdminRecord) []string {
+	cc1 := r.CountryCode + "." + r.Admin1Code
+	cc2 := cc1 + "." + r.Admin2Code
+	lat, lon := r.latText, r.lonText
+	if lat == "" || lon == "" {
+		lat, lon = strconv.FormatFloat(r.Latitude, 'f', -1, 64), strconv.FormatFloat(r.Longitude, 'f', -1, 64)
+	}
+	return []string{
+		lat,
+		lon,
+		r.ASCIIName,
+		admin1[cc1].ASCIIName,
+		admin2[cc2].ASCIIName,
+		r.CountryCode,
+		strconv.Itoa(r.GeoNameID),
+		strconv.Itoa(r.Population),
+		r.FeatureClass,
+		r.FeatureCode,
+		r.Timezone,
+		strconv.Itoa(r.Elevation),
+		r.Admin1Code,
+		r.Admin2Code,
+	}
+}
+
+// ParseAdminCodes 解析 admin1CodesASCII.txt / admin2Codes.txt，按 concat code 建索引
+func ParseAdminCodes(r io.Reader) (map[string]AdminRecord, error) {
+	out := make(map[string]AdminRecord)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) <= adminASCIIName {
+			return nil, fmt.Errorf("admin line %d: expected at least %d columns, got %d", line, adminASCIIName+1, len(fields))
+		}
+		rec := AdminRecord{
+			ConcatCodes: fields[adminConcatCodes],
+			Name:        fields[adminName],
+			ASCIIName:   fields[adminASCIIName],
+		}
+		if len(fields) > adminGeoNameID {
+			rec.GeoNameID, _ = strconv.Atoi(fields[adminGeoNameID])
+		}
+		out[rec.ConcatCodes] = rec
+	}
+	return out, scanner.Err()
+}
+
+func loadAdminFile(path string) (map[string]AdminRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ParseAdminCodes(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免留下半成品
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp 默认 0600，数据文件需要能被其他进程读取
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,157 @@
+package rgeocoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Source Reload 的数据来源：按给定配置（当前实例配置的副本）加载出新的数据与KD树
+type Source func(cfg *Config) (*RGeocoder, error)
+
+// DataDirSource 按 NewRGeocoder 的规则重新加载 DataDir（或 DataFS）中的数据集
+func DataDirSource() Source { return loadDataset }
+
+// FileSource 从文件加载：.snap / .snap.gz 按快照读取（配置了 WithMmap 时映射 .snap），
+// 其他按 CSV 读取（压缩格式按魔数识别）
+func FileSource(path string) Source {
+	return func(cfg *Config) (*RGeocoder, error) {
+		switch {
+		case strings.HasSuffix(path, ".snap") && cfg.Mmap:
+			return openMapped(path, cfg)
+		case strings.HasSuffix(path, ".snap"), strings.HasSuffix(path, ".snap.gz"):
+			return loadSnapshot(path, cfg)
+		}
+		loader := NewDataLoader(cfg)
+		coords, locs, err := loader.LoadFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", path, err)
+		}
+		return newLoadedGeocoder(cfg, loader, coords, locs), nil
+	}
+}
+
+// ReaderSource 从 CSV 流加载
+func ReaderSource(r io.Reader) Source {
+	return func(cfg *Config) (*RGeocoder, error) {
+		loader := NewDataLoader(cfg)
+		coords, locs, err := loader.LoadFromStream(r)
+		if err != nil {
+			return nil, err
+		}
+		return newLoadedGeocoder(cfg, loader, coords, locs), nil
+	}
+}
+
+// Reload 从 src 加载新数据并建树，完成后原子替换当前数据：替换前开始的查询仍在旧数据上完成，
+// 之后的查询只看到新数据，旧数据在这些查询结束后释放。加载失败时保留当前数据并返回错误。
+// 加载期间不持有锁，Close 与其他 Reload 不必等待；加载完成时 rg 已关闭则丢弃新数据并返回 ErrClosed。
+// 并发的 Reload 以最后完成的为准。Add/Update/Remove 的修改不会带到新数据中。
+func (rg *RGeocoder) Reload(src Source) error {
+	if rg.isClosed() {
+		return ErrClosed
+	}
+	cfg := *rg.currentConfig()
+	start := time.Now()
+	next, err := src(&cfg)
+	if err != nil {
+		err = fmt.Errorf("reload: %w", err)
+	} else if !rg.swap(next) {
+		next.Close()
+		return ErrClosed
+	} else {
+		if cfg.Verbose {
+			fmt.Printf("reloaded %d locations in %v\n", next.Len(), time.Since(start))
+		}
+	}
+	if cfg.OnReload != nil {
+		cfg.OnReload(err)
+	}
+	return err
+}
+
+// swap 原子换入 next 的数据并释放旧数据的引用：不等待进行中的查询，旧索引由最后一个持有者关闭。
+// rg 已关闭时不换入，返回 false。
+func (rg *RGeocoder) swap(next *RGeocoder) bool {
+	rg.reloadMu.Lock()
+	defer rg.reloadMu.Unlock()
+	if rg.closed {
+		return false
+	}
+	rg.cur.Swap(next.cur.Load()).release()
+	return true
+}
+
+func (rg *RGeocoder) isClosed() bool {
+	rg.reloadMu.Lock()
+	defer rg.reloadMu.Unlock()
+	return rg.closed
+}
+
+// startWatch 启动后台监视（loaded 为当前数据对应的文件状态）：DataDir 中的 CSV 或快照的大小、修改时间变化，
+// 并且连续两次检查结果相同（写入已完成）、Updater 不在安装中时执行一次 Reload(DataDirSource())
+func (rg *RGeocoder) startWatch(interval time.Duration, loaded string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rg.watchStop = cancel
+	cfg := rg.currentConfig()
+	paths := watchPaths(cfg)
+	marker := installMarkerPath(cfg.DataDir, cfg.dataset())
+	go func() {
+		seen := loaded
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur := statFiles(paths)
+			if cur != seen {
+				seen = cur
+				continue
+			}
+			if cur == loaded || fileExists(marker) {
+				continue
+			}
+			// 失败也不重试同一版本，等文件再次变化
+			loaded = cur
+			if err := rg.Reload(DataDirSource()); err != nil && !errors.Is(err, ErrClosed) && rg.verbose {
+				fmt.Println("watch:", err)
+			}
+		}
+	}()
+}
+
+// watching 是否启用了 WithWatch
+func (rg *RGeocoder) watching() bool { return rg.watchStop != nil }
+
+// stopWatch 停止监视，可重复调用。不等待进行中的 Reload：它完成时发现已关闭会丢弃新数据
+func (rg *RGeocoder) stopWatch() {
+	if rg.watchStop != nil {
+		rg.watchStop()
+	}
+}
+
+// watchPaths 监视的文件：数据集 CSV 与其快照
+func watchPaths(cfg *Config) []string {
+	ds := cfg.dataset()
+	return []string{datasetPath(cfg.DataDir, ds), DatasetSnapshotPath(cfg.DataDir, ds)}
+}
+
+// statFiles 文件大小与修改时间的摘要，不存在的文件记为 "-"
+func statFiles(paths []string) string {
+	var sb strings.Builder
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&sb, "%d@%d;", fi.Size(), fi.ModTime().UnixNano())
+		} else {
+			sb.WriteString("-;")
+		}
+	}
+	return sb.String()
+}
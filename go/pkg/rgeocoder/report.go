@@ -0,0 +1,107 @@
+package rgeocoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxReportSamples LoadReport 中保留的被拒绝行样本数
+const maxReportSamples = 20
+
+// RejectReason 数据行被拒绝的原因
+type RejectReason string
+
+const (
+	RejectFieldCount RejectReason = "field_count"  // 列数与表头不一致
+	RejectMalformed  RejectReason = "malformed"    // CSV 语法错误（如引号不匹配）
+	RejectBadFloat   RejectReason = "bad_float"    // lat/lon 不是数字
+	RejectBadInt     RejectReason = "bad_int"      // geonameid/population/elevation 不是整数
+	RejectBadDate    RejectReason = "bad_date"     // GeoNames 修改日期不是 YYYY-MM-DD
+	RejectNaN        RejectReason = "nan"          // lat/lon 为 NaN
+	RejectOutOfRange RejectReason = "out_of_range" // 超出 [-90,90] / [-180,180]（含 ±Inf）
+	RejectDuplicate  RejectReason = "duplicate"    // 与之前某行完全相同
+)
+
+// RejectedRow 一条被拒绝的数据行
+type RejectedRow struct {
+	Line   int          `json:"line"` // 文件中的行号（从 1 开始，含表头）
+	Reason RejectReason `json:"reason"`
+	Detail string       `json:"detail"`
+	Record []string     `json:"record,omitempty"`
+}
+
+// LoadReport 宽松模式加载 CSV 的统计：行数、各原因的拒绝数与部分样本
+type LoadReport struct {
+	Rows     int                  `json:"rows"` // 数据行数（不含表头）
+	Loaded   int                  `json:"loaded"`
+	Rejected int                  `json:"rejected"`
+	Reasons  map[RejectReason]int `json:"reasons"`
+	Samples  []RejectedRow        `json:"samples"` // 最多 maxReportSamples 条
+}
+
+func newLoadReport() *LoadReport {
+	return &LoadReport{Reasons: make(map[RejectReason]int)}
+}
+
+func (r *LoadReport) reject(row RejectedRow) {
+	r.Rejected++
+	r.Reasons[row.Reason]++
+	if len(r.Samples) < maxReportSamples {
+		r.Samples = append(r.Samples, row)
+	}
+}
+
+// String 单行摘要，便于日志输出
+func (r *LoadReport) String() string {
+	return fmt.Sprintf("rows=%d loaded=%d rejected=%d reasons=%v", r.Rows, r.Loaded, r.Rejected, r.Reasons)
+}
+
+// RowError 严格模式下遇到的第一条坏行
+type RowError struct {
+	RejectedRow
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Reason, e.Detail)
+}
+
+// parseLatLon 解析并校验坐标字符串，失败时返回原因与说明
+func parseLatLon(latS, lonS string) (Coordinate, RejectReason, string) {
+	lat, err := strconv.ParseFloat(latS, 64)
+	if err != nil {
+		return Coordinate{}, RejectBadFloat, fmt.Sprintf("lat %q", latS)
+	}
+	lon, err := strconv.ParseFloat(lonS, 64)
+	if err != nil {
+		return Coordinate{}, RejectBadFloat, fmt.Sprintf("lon %q", lonS)
+	}
+	if math.IsNaN(lat) || math.IsNaN(lon) {
+		return Coordinate{}, RejectNaN, fmt.Sprintf("lat %q lon %q", latS, lonS)
+	}
+	c := Coordinate{Lat: lat, Lon: lon}
+	if ValidateCoordinate(c) != nil {
+		return Coordinate{}, RejectOutOfRange, fmt.Sprintf("lat %q lon %q", latS, lonS)
+	}
+	return c, "", ""
+}
+
+// rowSet 记录已出现的行，以整行内容为键，不会把不同的行误判为重复
+type rowSet map[string]struct{}
+
+// add 加入一行，已存在时返回 false。每个字段前缀其长度，字段内容中的任何字符都不会造成歧义。
+func (s rowSet) add(rec []string) bool {
+	var sb strings.Builder
+	for _, f := range rec {
+		sb.WriteString(strconv.Itoa(len(f)))
+		sb.WriteByte(':')
+		sb.WriteString(f)
+	}
+	key := sb.String()
+	if _, ok := s[key]; ok {
+		return false
+	}
+	s[key] = struct{}{}
+	return true
+}
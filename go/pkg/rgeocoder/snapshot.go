@@ -0,0 +1,572 @@
+package rgeocoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 快照文件格式（小端）：
+//
+//	header  128 字节：magic[8] version u32 mode u32 count u64 nodes u64 bodySum[32]
+//	        sourceSize u64 sourceSum[32] sourceModTime i64（UnixNano），其余补零
+//	nodes   nodes 个 flatNode，每个 32 字节
+//	ll      树序 lat,lon（2n 个 f64）
+//	pts     树序 ECEF x,y,z（3n 个 f64，仅 ECEF 模式）
+//	cos     树序 cos(lat)（n 个 f64，仅 Haversine 模式）
+//	coords  原始顺序 lat,lon（2n 个 f64）
+//	index   槽位 -> 原始索引（n 个 i32）
+//	strOff  字符串偏移（kn+1 个 u32），第 i 个位置的第 j 个字段为 blob[off[ki+j]:off[ki+j+1]]，
+//	        字段依次为 lat lon name admin1 admin2 cc、JSON 编码的 Attributes（无则为空），
+//	        以及 geonameid population feature_class feature_code timezone elevation admin1_code admin2_code（0 记为空）
+//	blob    字符串数据
+//
+// 各段起始位置按 8 字节对齐，便于 mmap 后按切片直接访问。bodySum 为 header 之后全部内容（快照自身）的 SHA-256，
+// 用于发现文件损坏；sourceSize / sourceSum / sourceModTime 为生成快照的数据集 CSV 文件的大小、SHA-256
+// 与修改时间（未知时为 0），启动时据此判断快照是否仍对应 DataDir 中的 CSV。
+// 每个位置的字段数 k 为 15。只读取当前版本；版本不符的快照被忽略，由 CSV 重新生成。
+const (
+	snapshotMagic      = "RGEOSNAP"
+	snapshotVersion    = 4
+	snapshotHeaderSize = 128
+	snapshotFields     = 15
+	flatNodeSize       = 32
+)
+
+// ErrBadSnapshot 快照文件损坏、版本不兼容或校验失败
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+// snapshotSource 生成快照的数据集 CSV 文件，零值表示未知（从流、编入数据集或修改过的数据生成）
+type snapshotSource struct {
+	Size    uint64
+	Sum     [sha256.Size]byte
+	ModTime int64 // UnixNano
+}
+
+func (s snapshotSource) known() bool { return s.Size > 0 }
+
+// snapshotHeader 快照文件头
+type snapshotHeader struct {
+	Version uint32
+	Mode    DistanceMode
+	Count   uint64
+	Nodes   uint64
+	BodySum [sha256.Size]byte // 快照内容（header 之后）的 SHA-256
+	Source  snapshotSource    // 版本 4 起
+}
+
+func (h snapshotHeader) marshal() []byte {
+	b := make([]byte, snapshotHeaderSize)
+	copy(b, snapshotMagic)
+	binary.LittleEndian.PutUint32(b[8:], h.Version)
+	binary.LittleEndian.PutUint32(b[12:], uint32(h.Mode))
+	binary.LittleEndian.PutUint64(b[16:], h.Count)
+	binary.LittleEndian.PutUint64(b[24:], h.Nodes)
+	copy(b[32:], h.BodySum[:])
+	binary.LittleEndian.PutUint64(b[64:], h.Source.Size)
+	copy(b[72:], h.Source.Sum[:])
+	binary.LittleEndian.PutUint64(b[104:], uint64(h.Source.ModTime))
+	return b
+}
+
+// parseSnapshotHeader 解析并校验文件头（不校验 bodySum）
+func parseSnapshotHeader(b []byte) (snapshotHeader, error) {
+	var h snapshotHeader
+	if len(b) < snapshotHeaderSize || string(b[:8]) != snapshotMagic {
+		return h, fmt.Errorf("%w: bad magic", ErrBadSnapshot)
+	}
+	h.Version = binary.LittleEndian.Uint32(b[8:])
+	h.Mode = DistanceMode(binary.LittleEndian.Uint32(b[12:]))
+	h.Count = binary.LittleEndian.Uint64(b[16:])
+	h.Nodes = binary.LittleEndian.Uint64(b[24:])
+	copy(h.BodySum[:], b[32:64])
+	h.Source.Size = binary.LittleEndian.Uint64(b[64:])
+	copy(h.Source.Sum[:], b[72:104])
+	h.Source.ModTime = int64(binary.LittleEndian.Uint64(b[104:]))
+	if h.Version != snapshotVersion {
+		return h, fmt.Errorf("%w: format version %d, want %d", ErrBadSnapshot, h.Version, snapshotVersion)
+	}
+	if h.Mode > DistanceECEF {
+		return h, fmt.Errorf("%w: unknown distance mode %d", ErrBadSnapshot, h.Mode)
+	}
+	if h.Count > math.MaxInt32 || h.Nodes > 2*h.Count+1 {
+		return h, fmt.Errorf("%w: bad counts %d/%d", ErrBadSnapshot, h.Count, h.Nodes)
+	}
+	return h, nil
+}
+
+// readSnapshotHeader 只读取文件头
+func readSnapshotHeader(path string) (snapshotHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return snapshotHeader{}, err
+	}
+	defer f.Close()
+	b := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return snapshotHeader{}, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	return parseSnapshotHeader(b)
+}
+
+// snapshotLayout 各段在文件中的字节偏移；不存在的段为 -1
+type snapshotLayout struct {
+	nodes, ll, pts, cos, coords, index, strOff, blob int64
+}
+
+func (h snapshotHeader) layout() snapshotLayout {
+	n := int64(h.Count)
+	off := int64(snapshotHeaderSize)
+	next := func(size int64) int64 {
+		start := off
+		off += align8(size)
+		return start
+	}
+	l := snapshotLayout{pts: -1, cos: -1}
+	l.nodes = next(int64(h.Nodes) * flatNodeSize)
+	l.ll = next(16 * n)
+	if h.Mode == DistanceECEF {
+		l.pts = next(24 * n)
+	}
+	if h.Mode == DistanceHaversine {
+		l.cos = next(8 * n)
+	}
+	l.coords = next(16 * n)
+	l.index = next(4 * n)
+	l.strOff = next(4 * (int64(snapshotFields)*n + 1))
+	l.blob = off
+	return l
+}
+
+func align8(n int64) int64 { return (n + 7) &^ 7 }
+
+// DefaultSnapshotPath DataDir 下默认数据集（cities1000）的快照路径，NewRGeocoder 会优先加载它
+func DefaultSnapshotPath(dataDir string) string {
+	return DatasetSnapshotPath(dataDir, Cities1000)
+}
+
+// DatasetSnapshotPath DataDir 下数据集 ds 的快照路径（rg_<Name>.snap），配置了该数据集时 NewRGeocoder 会优先加载它
+func DatasetSnapshotPath(dataDir string, ds Dataset) string {
+	return filepath.Join(dataDir, "rg_"+ds.Name+".snap")
+}
+
+// SaveSnapshot 将位置数据与构建好的扁平KD树写入二进制快照（原子替换），路径以 .gz 结尾时 gzip 压缩；
+// 有未合并的增量修改时先重建。从数据集文件加载且未修改过的数据会在快照中记录该文件的大小与哈希。
+func (rg *RGeocoder) SaveSnapshot(path string) error {
+	d, err := rg.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
+	src := d.source
+	if d.modified.Load() {
+		src = snapshotSource{}
+	}
+	ix := d.idx
+	return ix.compacted(func(tree KDTreeInterface, coords []Coordinate, locs locationStore) error {
+		return saveSnapshot(path, flatTree(tree, coords, ix.config.DistanceMode), coords, locs, src)
+	})
+}
+
+func saveSnapshot(path string, t *FlatKDTree, coords []Coordinate, locs locationStore, src snapshotSource) error {
+	sum := sha256.New()
+	buf := bufio.NewWriter(sum)
+	if err := writeSnapshotBody(buf, t, coords, locs); err != nil {
+		return err
+	}
+	buf.Flush()
+	h := snapshotHeader{
+		Version: snapshotVersion,
+		Mode:    t.mode,
+		Count:   uint64(len(coords)),
+		Nodes:   uint64(len(t.nodes)),
+		Source:  src,
+	}
+	copy(h.BodySum[:], sum.Sum(nil))
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		var zw *gzip.Writer
+		if strings.HasSuffix(path, ".gz") {
+			zw, _ = gzip.NewWriterLevel(w, gzip.BestCompression)
+			w = zw
+		}
+		if _, err := w.Write(h.marshal()); err != nil {
+			return err
+		}
+		if err := writeSnapshotBody(w, t, coords, locs); err != nil {
+			return err
+		}
+		if zw != nil {
+			return zw.Close()
+		}
+		return nil
+	})
+}
+
+// flatTree 取出当前使用的扁平树，其他布局则按当前数据现建一棵
+func flatTree(tree KDTreeInterface, coords []Coordinate, mode DistanceMode) *FlatKDTree {
+	base := tree
+	if mp, ok := base.(*KDTreeMP); ok {
+		base = mp.base
+	}
+	switch t := base.(type) {
+	case *FlatKDTree:
+		return t
+	case *MappedIndex:
+		return t.FlatKDTree
+	}
+	return NewFlatKDTree(coords, mode)
+}
+
+// locationFields 快照中一个位置的字符串字段
+func locationFields(l *Location) [snapshotFields]string {
+	var attrs string
+	if len(l.Attributes) > 0 {
+		b, _ := json.Marshal(l.Attributes)
+		attrs = string(b)
+	}
+	return [snapshotFields]string{l.Lat, l.Lon, l.Name, l.Admin1, l.Admin2, l.CC, attrs,
+		itoa0(l.GeoNameID), itoa0(l.Population), l.FeatureClass, l.FeatureCode, l.Timezone, itoa0(l.Elevation), l.Admin1Code, l.Admin2Code}
+}
+
+// locationFromFields locationFields 的逆过程
+func locationFromFields(f []string) Location {
+	loc := Location{Lat: f[0], Lon: f[1], Name: f[2], Admin1: f[3], Admin2: f[4], CC: f[5]}
+	if f[6] != "" {
+		_ = json.Unmarshal([]byte(f[6]), &loc.Attributes)
+	}
+	loc.GeoNameID, _ = strconv.Atoi(f[7])
+	loc.Population, _ = strconv.Atoi(f[8])
+	loc.FeatureClass, loc.FeatureCode, loc.Timezone = f[9], f[10], f[11]
+	loc.Elevation, _ = strconv.Atoi(f[12])
+	loc.Admin1Code, loc.Admin2Code = f[13], f[14]
+	return loc
+}
+
+func itoa0(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+func writeSnapshotBody(w io.Writer, t *FlatKDTree, coords []Coordinate, locs locationStore) error {
+	if locs.Len() != len(coords) {
+		return fmt.Errorf("snapshot: %d locations for %d coordinates", locs.Len(), len(coords))
+	}
+	bw := &binWriter{w: w}
+	for _, n := range t.nodes {
+		bw.f64(n.Split)
+		bw.u32(uint32(n.Axis))
+		bw.u32(uint32(n.Left))
+		bw.u32(uint32(n.Right))
+		bw.u32(uint32(n.Start))
+		bw.u32(uint32(n.End))
+		bw.u32(0)
+	}
+	bw.f64s(t.ll)
+	if t.mode == DistanceECEF {
+		bw.f64s(t.pts)
+	}
+	if t.mode == DistanceHaversine {
+		bw.f64s(t.cos)
+	}
+	for _, c := range coords {
+		bw.f64(c.Lat)
+		bw.f64(c.Lon)
+	}
+	for _, i := range t.index {
+		bw.u32(uint32(i))
+	}
+	bw.pad()
+
+	var off uint64
+	bw.u32(0)
+	for i := 0; i < locs.Len(); i++ {
+		loc := locs.At(i)
+		for _, s := range locationFields(&loc) {
+			off += uint64(len(s))
+			if off > math.MaxUint32 {
+				return errors.New("snapshot: string data exceeds 4GiB")
+			}
+			bw.u32(uint32(off))
+		}
+	}
+	bw.pad()
+	for i := 0; i < locs.Len(); i++ {
+		loc := locs.At(i)
+		for _, s := range locationFields(&loc) {
+			bw.write([]byte(s))
+		}
+	}
+	return bw.err
+}
+
+// binWriter 顺序写入小端数值，记录第一个错误
+type binWriter struct {
+	w   io.Writer
+	n   int64
+	buf [8]byte
+	err error
+}
+
+func (w *binWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	m, err := w.w.Write(b)
+	w.n += int64(m)
+	w.err = err
+}
+
+func (w *binWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *binWriter) f64(v float64) {
+	binary.LittleEndian.PutUint64(w.buf[:], math.Float64bits(v))
+	w.write(w.buf[:])
+}
+
+func (w *binWriter) f64s(vs []float64) {
+	for _, v := range vs {
+		w.f64(v)
+	}
+}
+
+// pad 补零到 8 字节边界
+func (w *binWriter) pad() {
+	var zero [8]byte
+	w.write(zero[:align8(w.n)-w.n])
+}
+
+// LoadSnapshot 从快照创建实例，跳过 CSV 解析与建树。
+// 距离模式取快照中记录的值，树布局固定为 TreeFlat；其余选项照常生效。
+func LoadSnapshot(path string, opts ...Option) (*RGeocoder, error) {
+	return loadSnapshot(path, applyOptions(opts))
+}
+
+func loadSnapshot(path string, cfg *Config) (*RGeocoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := parseSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Verbose {
+		fmt.Printf("loaded %d locations from snapshot %s\n", len(snap.locs), path)
+	}
+	return snap.geocoder(cfg), nil
+}
+
+// snapshotData 解码后的快照内容
+type snapshotData struct {
+	mode   DistanceMode
+	tree   *FlatKDTree
+	coords []Coordinate
+	locs   []Location
+	source snapshotSource
+}
+
+// geocoder 直接使用快照中的树创建实例，距离模式以快照为准
+func (s *snapshotData) geocoder(cfg *Config) *RGeocoder {
+	cfg.DistanceMode = s.mode
+	cfg.TreeLayout = TreeFlat
+	rg := newGeocoder(cfg, s.coords, locationSlice(s.locs), wrapTree(s.tree, cfg))
+	rg.cur.Load().source = s.source
+	return rg
+}
+
+// parseSnapshot 校验并解码快照字节；gzip 压缩的快照先解压
+func parseSnapshot(data []byte) (*snapshotData, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+		}
+	}
+	h, err := parseSnapshotHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(data[snapshotHeaderSize:]) != h.BodySum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+	t, coords, locs, err := decodeSnapshot(h, data)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotData{mode: h.Mode, tree: t, coords: coords, locs: locs, source: h.Source}, nil
+}
+
+// decodeSnapshot 按布局把各段复制成 Go 切片
+func decodeSnapshot(h snapshotHeader, data []byte) (*FlatKDTree, []Coordinate, []Location, error) {
+	l := h.layout()
+	n := int(h.Count)
+	if int64(len(data)) < l.blob {
+		return nil, nil, nil, fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+	t := &FlatKDTree{mode: h.Mode, dim: 2, nodes: make([]flatNode, h.Nodes)}
+	for i := range t.nodes {
+		b := data[l.nodes+int64(i)*flatNodeSize:]
+		t.nodes[i] = flatNode{
+			Split: math.Float64frombits(binary.LittleEndian.Uint64(b)),
+			Axis:  int32(binary.LittleEndian.Uint32(b[8:])),
+			Left:  int32(binary.LittleEndian.Uint32(b[12:])),
+			Right: int32(binary.LittleEndian.Uint32(b[16:])),
+			Start: int32(binary.LittleEndian.Uint32(b[20:])),
+			End:   int32(binary.LittleEndian.Uint32(b[24:])),
+		}
+	}
+	t.ll = decodeF64s(data[l.ll:], 2*n)
+	t.pts = t.ll
+	if l.pts >= 0 {
+		t.dim = 3
+		t.pts = decodeF64s(data[l.pts:], 3*n)
+	}
+	if l.cos >= 0 {
+		t.cos = decodeF64s(data[l.cos:], n)
+	}
+	t.index = make([]int32, n)
+	for i := range t.index {
+		t.index[i] = int32(binary.LittleEndian.Uint32(data[l.index+4*int64(i):]))
+	}
+	if err := t.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	raw := decodeF64s(data[l.coords:], 2*n)
+	coords := make([]Coordinate, n)
+	for i := range coords {
+		coords[i] = Coordinate{Lat: raw[2*i], Lon: raw[2*i+1]}
+	}
+
+	k := snapshotFields
+	offs := make([]uint32, k*n+1)
+	for i := range offs {
+		offs[i] = binary.LittleEndian.Uint32(data[l.strOff+4*int64(i):])
+	}
+	if int64(len(data)) != l.blob+int64(offs[len(offs)-1]) {
+		return nil, nil, nil, fmt.Errorf("%w: string table size mismatch", ErrBadSnapshot)
+	}
+	// 所有字段共用一份字符串内存
+	blob := string(data[l.blob:])
+	locs := make([]Location, n)
+	for i := range locs {
+		f := make([]string, k)
+		for j := range f {
+			lo, hi := offs[k*i+j], offs[k*i+j+1]
+			if lo > hi {
+				return nil, nil, nil, fmt.Errorf("%w: bad string offset", ErrBadSnapshot)
+			}
+			f[j] = blob[lo:hi]
+		}
+		locs[i] = locationFromFields(f)
+	}
+	return t, coords, locs, nil
+}
+
+func decodeF64s(b []byte, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return out
+}
+
+// validate 检查节点引用与索引范围，避免损坏的快照在查询时越界
+func (t *FlatKDTree) validate() error {
+	n := int32(len(t.index))
+	for _, nd := range t.nodes {
+		if nd.Start < 0 || nd.End > n || nd.Start > nd.End {
+			return fmt.Errorf("%w: node range out of bounds", ErrBadSnapshot)
+		}
+		if nd.Axis >= 0 && (nd.Axis >= int32(t.dim) || nd.Left <= 0 || nd.Right <= 0 ||
+			int(nd.Left) >= len(t.nodes) || int(nd.Right) >= len(t.nodes)) {
+			return fmt.Errorf("%w: bad node reference", ErrBadSnapshot)
+		}
+	}
+	for _, i := range t.index {
+		if i < 0 || i >= n {
+			return fmt.Errorf("%w: index out of range", ErrBadSnapshot)
+		}
+	}
+	return nil
+}
+
+// loadFreshSnapshot DataDir 中存在与 CSV 对应、距离模式一致且校验通过的快照时直接加载。
+// 快照记录了来源 CSV 的大小与哈希时逐一比较；旧版本或来源未知的快照退回到比较修改时间。
+func loadFreshSnapshot(cfg *Config, csvPath string) (*RGeocoder, bool) {
+	path := DatasetSnapshotPath(cfg.DataDir, cfg.dataset())
+	snap, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	h, err := readSnapshotHeader(path)
+	if err == nil {
+		if stale, reason := snapshotStale(h, snap, csvPath); stale {
+			if cfg.Verbose {
+				fmt.Printf("snapshot %s, ignoring: %s\n", reason, path)
+			}
+			return nil, false
+		}
+	}
+	if err == nil && h.Mode != cfg.DistanceMode {
+		err = fmt.Errorf("snapshot distance mode %d, want %d", h.Mode, cfg.DistanceMode)
+	}
+	var rg *RGeocoder
+	if err == nil && cfg.Mmap {
+		rg, err = openMapped(path, cfg)
+	} else if err == nil {
+		rg, err = loadSnapshot(path, cfg)
+	}
+	if err != nil {
+		if cfg.Verbose {
+			fmt.Println("ignoring snapshot:", err)
+		}
+		return nil, false
+	}
+	return rg, true
+}
+
+// snapshotStale 快照是否不再对应 csvPath；CSV 不存在时快照总是可用
+func snapshotStale(h snapshotHeader, snap os.FileInfo, csvPath string) (bool, string) {
+	fi, err := os.Stat(csvPath)
+	if err != nil {
+		return false, ""
+	}
+	if !h.Source.known() {
+		return fi.ModTime().After(snap.ModTime()), "is older than dataset"
+	}
+	if uint64(fi.Size()) != h.Source.Size {
+		return true, "was built from a different dataset"
+	}
+	// 大小与修改时间都与记录一致时不读取 CSV；修改时间变化（如 touch、复制）时才比较哈希
+	if fi.ModTime().UnixNano() == h.Source.ModTime {
+		return false, ""
+	}
+	sum, err := hashFile(csvPath)
+	if err != nil || !bytes.Equal(sum, h.Source.Sum[:]) {
+		return true, "was built from a different dataset"
+	}
+	return false, ""
+}
@@ -0,0 +1,366 @@
+package rgeocoder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpdatePolicy 新数据集换入前的校验规则。行数阈值为 0 时按当前数据的规模推算，
+// 同一套规则适用于从 cities15000 到 allCountries 的各个数据集。
+type UpdatePolicy struct {
+	MinRows         int     // 新数据集至少的行数，0 时取当前行数的 MinRowsRatio
+	MinRowsRatio    float64 // MinRows 为 0 时新数据集相对当前数据的最少比例（0.9 即不少于 90%）
+	MaxCountryDelta float64 // 每个国家行数相对当前数据允许的最大变化比例（0.2 即 ±20%），<= 0 不检查
+	// MinCountryRows 当前数据中行数不少于该值的国家才参与比较，避免小国家的正常波动误报；
+	// 0 时取当前行数的千分之一（至少 1）
+	MinCountryRows int
+}
+
+// DefaultUpdatePolicy 默认校验规则：行数不少于当前的 90%，较大国家的行数变化不超过 ±20%
+var DefaultUpdatePolicy = UpdatePolicy{MinRowsRatio: 0.9, MaxCountryDelta: 0.2}
+
+// thresholds 按当前数据的行数 current 得到实际的 MinRows 与 MinCountryRows
+func (p UpdatePolicy) thresholds(current int) (minRows, minCountryRows int) {
+	minRows, minCountryRows = p.MinRows, p.MinCountryRows
+	if minRows == 0 {
+		minRows = max(int(float64(current)*p.MinRowsRatio), 1)
+	}
+	if minCountryRows == 0 {
+		minCountryRows = max(current/1000, 1)
+	}
+	return minRows, minCountryRows
+}
+
+// ValidationError 新数据集未通过校验，Problems 逐条说明原因
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "dataset rejected: " + strings.Join(e.Problems, "; ")
+}
+
+// UpdateResult 一次更新的结果
+type UpdateResult struct {
+	Started  time.Time
+	Finished time.Time
+	Changed  bool  // 下载的原始文件与 DataDir 中的不同，并已换入新数据
+	Rows     int   // 新数据集的行数（未重新生成时为 0）
+	Err      error // 失败原因；*ValidationError 表示校验未通过，旧数据保持不变
+}
+
+// Updater 定期从 GeoNames（或 DownloadURLs 指向的镜像）下载原始文件，重新生成数据集，
+// 校验通过后写入 DataDir 并换入运行中的 RGeocoder（启用了 WithWatch 时交给监视器加载）。任何一步失败都保留旧数据。
+type Updater struct {
+	rg *RGeocoder
+
+	// Policy 换入前的校验规则，默认 DefaultUpdatePolicy
+	Policy UpdatePolicy
+	// Client 下载使用的 HTTP 客户端，nil 时使用 Downloader 的默认值
+	Client *http.Client
+	// OnResult 每次更新结束后回调（包括未变化和失败）
+	OnResult func(UpdateResult)
+
+	mu   sync.Mutex // 串行化更新，保护 last
+	last *UpdateResult
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewUpdater 为 rg 创建更新器，使用 rg 的 DataDir 与 DownloadURLs
+func NewUpdater(rg *RGeocoder) *Updater {
+	return &Updater{rg: rg, Policy: DefaultUpdatePolicy}
+}
+
+// Start 每隔 interval 执行一次 UpdateNow，直到 Stop；Stop 会中止进行中的下载
+func (u *Updater) Start(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	u.stop, u.done = cancel, make(chan struct{})
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				u.UpdateNow(ctx)
+			}
+		}
+	}()
+}
+
+// Stop 停止定时更新并等待进行中的更新结束
+func (u *Updater) Stop() {
+	if u.stop != nil {
+		u.stop()
+		<-u.done
+	}
+}
+
+// LastResult 最近一次更新的结果，尚未执行过时为 nil
+func (u *Updater) LastResult() *UpdateResult {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.last
+}
+
+// UpdateNow 立即执行一次更新，返回结果与其中的错误；ctx 取消时中止下载并保留旧数据
+func (u *Updater) UpdateNow(ctx context.Context) (UpdateResult, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	res := UpdateResult{Started: time.Now()}
+	res.Err = u.update(ctx, &res)
+	res.Finished = time.Now()
+	u.last = &res
+	if u.OnResult != nil {
+		u.OnResult(res)
+	}
+	return res, res.Err
+}
+
+func (u *Updater) update(ctx context.Context, res *UpdateResult) error {
+	cfg := *u.rg.currentConfig()
+	if err := EnsureDir(cfg.DataDir); err != nil {
+		return err
+	}
+	// 在 DataDir 内的临时目录下载与生成，通过后 rename 到位（同一文件系统）
+	staging, err := os.MkdirTemp(cfg.DataDir, ".update-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	stagingCfg := cfg
+	stagingCfg.DataDir = staging
+
+	d := NewDownloader(&stagingCfg)
+	if u.Client != nil {
+		d.Client = u.Client
+	}
+	// 以 DataDir 中上次下载的文件发送条件请求，304 的文件不下载
+	ds := cfg.dataset()
+	// 上次安装中断时 DataDir 中的文件可能新旧混杂，即使源文件未变化也重新生成
+	changed := !fileExists(datasetPath(cfg.DataDir, ds)) || fileExists(installMarkerPath(cfg.DataDir, ds))
+	var downloaded, unmodified []string
+	for _, name := range d.RequiredFiles() {
+		current := filepath.Join(cfg.DataDir, name)
+		modified, err := d.DownloadIfModified(ctx, name, current)
+		if err != nil {
+			return err
+		}
+		if !modified {
+			unmodified = append(unmodified, name)
+			continue
+		}
+		downloaded = append(downloaded, name)
+		if !changed {
+			same, err := sameFile(filepath.Join(staging, name), current)
+			if err != nil {
+				return err
+			}
+			changed = !same
+		}
+	}
+	if !changed {
+		if cfg.Verbose {
+			fmt.Println("update: source files unchanged")
+		}
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 未变化的文件链接到暂存目录，供重新生成使用
+	for _, name := range unmodified {
+		if err := linkOrCopy(filepath.Join(cfg.DataDir, name), filepath.Join(staging, name)); err != nil {
+			return err
+		}
+	}
+	if err := NewDataProcessor(&stagingCfg).ProcessGeoNamesData(); err != nil {
+		return fmt.Errorf("process: %w", err)
+	}
+	loader := NewDataLoader(&stagingCfg)
+	coords, locs, err := loader.LoadFromFile(datasetPath(staging, ds))
+	if err != nil {
+		return fmt.Errorf("load new dataset: %w", err)
+	}
+	res.Rows = len(locs)
+	if err := u.validate(locs); err != nil {
+		return err
+	}
+
+	// 下载的文件连同其 ETag 一起换入（服务端不再提供 ETag 时删除旧的）；全量更新同时重置增量状态
+	files := []string{filepath.Base(datasetPath(staging, ds)), filepath.Base(diffStatePath(staging, ds))}
+	for _, name := range downloaded {
+		files = append(files, name)
+		if fileExists(filepath.Join(staging, name+etagSuffix)) {
+			files = append(files, name+etagSuffix)
+		} else {
+			os.Remove(filepath.Join(cfg.DataDir, name+etagSuffix))
+		}
+	}
+	if err := install(staging, cfg.DataDir, ds, files); err != nil {
+		return err
+	}
+	res.Changed = true
+	next := newLoadedGeocoder(&cfg, loader, coords, locs)
+	if u.rg.watching() {
+		// 监视器会在安装完成后加载新数据，这里不再重复 Reload
+		next.Close()
+		if cfg.Verbose {
+			fmt.Printf("update: installed %d locations, waiting for watcher\n", len(locs))
+		}
+		return nil
+	}
+	if err := u.rg.Reload(func(*Config) (*RGeocoder, error) { return next, nil }); err != nil {
+		return err
+	}
+	if cfg.Verbose {
+		fmt.Printf("update: swapped in %d locations\n", len(locs))
+	}
+	return nil
+}
+
+// install 把 staging 中的 files 依次 rename 到 dataDir。期间存在安装标记（内容为文件清单），
+// 监视器看到标记时不加载；全部完成后最后删除标记。中断后标记保留，下次更新会重新生成。
+func install(staging, dataDir string, ds Dataset, files []string) error {
+	marker := installMarkerPath(dataDir, ds)
+	err := writeFileAtomic(marker, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(files, "\n")+"\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := os.Rename(filepath.Join(staging, name), filepath.Join(dataDir, name)); err != nil {
+			return fmt.Errorf("install %s: %w", name, err)
+		}
+	}
+	return os.Remove(marker)
+}
+
+// installMarkerPath Updater 安装数据集期间存在的标记文件
+func installMarkerPath(dataDir string, ds Dataset) string {
+	return strings.TrimSuffix(datasetPath(dataDir, ds), ".csv") + ".installing"
+}
+
+// validate 按 Policy 比较新数据集与当前数据
+func (u *Updater) validate(locs []Location) error {
+	p := u.Policy
+	current := u.rg.countryCounts()
+	rows := 0
+	for _, n := range current {
+		rows += n
+	}
+	minRows, minCountryRows := p.thresholds(rows)
+	var problems []string
+	if len(locs) < minRows {
+		problems = append(problems, fmt.Sprintf("%d rows, minimum is %d", len(locs), minRows))
+	}
+	if p.MaxCountryDelta > 0 {
+		next := make(map[string]int)
+		for _, l := range locs {
+			next[l.CC]++
+		}
+		codes := make([]string, 0, len(current))
+		for cc := range current {
+			codes = append(codes, cc)
+		}
+		slices.Sort(codes)
+		for _, cc := range codes {
+			old := current[cc]
+			if old < minCountryRows {
+				continue
+			}
+			delta := float64(next[cc]-old) / float64(old)
+			if math.Abs(delta) > p.MaxCountryDelta {
+				problems = append(problems, fmt.Sprintf("country %s: %d -> %d rows (%+.1f%%)", cc, old, next[cc], delta*100))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// countryCounts 当前数据中每个国家的位置数，已关闭时为空
+func (rg *RGeocoder) countryCounts() map[string]int {
+	counts := make(map[string]int)
+	ix, release, err := rg.index()
+	if err != nil {
+		return counts
+	}
+	defer release()
+	ix.each(func(l Location) { counts[l.CC]++ })
+	return counts
+}
+
+// sameFile 两个文件内容相同：先比较大小，再流式比较 SHA-256
+func sameFile(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil || fa.Size() != fb.Size() {
+		return false, nil
+	}
+	ha, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ha, hb), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// linkOrCopy 把 src 硬链接到 dst（同一文件系统），不支持时复制
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -31,11 +31,28 @@ func ValidateCoordinates(cs []Coordinate) error {
 	return nil
 }
 
-// GeodeticToECEF 简化版本（占位）
+// GeodeticToECEF 将WGS84大地坐标转换为地心地固坐标 (x, y, z)，单位 km。
+// 对应Python版本的 geodetic_in_ecef（Python 中 z 误用了角度制 sin(lat)，这里使用弧度）。
 func GeodeticToECEF(coords []Coordinate) [][]float64 {
-	out := make([][][]float64, 0)
-	_ = out
-	return [][]float64{}
+	out := make([][]float64, len(coords))
+	for i, c := range coords {
+		p := toECEF(c)
+		out[i] = p[:]
+	}
+	return out
+}
+
+// toECEF 单点转换
+func toECEF(c Coordinate) [3]float64 {
+	latR := c.Lat * math.Pi / 180
+	lonR := c.Lon * math.Pi / 180
+	sinLat := math.Sin(latR)
+	normal := WGS84MajorAxis / math.Sqrt(1-WGS84EccentricitySquared*sinLat*sinLat)
+	return [3]float64{
+		normal * math.Cos(latR) * math.Cos(lonR),
+		normal * math.Cos(latR) * math.Sin(lonR),
+		normal * (1 - WGS84EccentricitySquared) * sinLat,
+	}
 }
 
 // HaversineDistance 供外部使用
@@ -50,6 +67,77 @@ func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return EarthRadius * c
 }
 
+// unwrapRing 展开多边形经度，使相邻顶点经度差不超过180度（处理跨反子午线的环）。
+// 展开后首尾经度相差360度说明环绕过极点，不支持。
+func unwrapRing(ring []Coordinate) ([]Coordinate, error) {
+	out := make([]Coordinate, len(ring))
+	out[0] = ring[0]
+	for i := 1; i < len(ring); i++ {
+		lon := ring[i].Lon
+		prev := out[i-1].Lon
+		for lon-prev > 180 {
+			lon -= 360
+		}
+		for prev-lon > 180 {
+			lon += 360
+		}
+		out[i] = Coordinate{Lat: ring[i].Lat, Lon: lon}
+	}
+	if math.Abs(ring[0].Lon-out[len(out)-1].Lon) > 180 {
+		return nil, errors.New("polygons enclosing a pole are not supported")
+	}
+	return out, nil
+}
+
+// ringBounds 返回环的经纬度范围
+func ringBounds(ring []Coordinate) (minLat, minLon, maxLat, maxLon float64) {
+	minLat, minLon = math.Inf(1), math.Inf(1)
+	maxLat, maxLon = math.Inf(-1), math.Inf(-1)
+	for _, c := range ring {
+		minLat, maxLat = math.Min(minLat, c.Lat), math.Max(maxLat, c.Lat)
+		minLon, maxLon = math.Min(minLon, c.Lon), math.Max(maxLon, c.Lon)
+	}
+	return
+}
+
+// pointInRing 射线法（奇偶规则）判断点是否在环内，按经纬度平面计算
+func pointInRing(p Coordinate, ring []Coordinate) bool {
+	inside := false
+	j := len(ring) - 1
+	for i := range ring {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) {
+			lon := a.Lon + (p.Lat-a.Lat)*(b.Lon-a.Lon)/(b.Lat-a.Lat)
+			if p.Lon < lon {
+				inside = !inside
+			}
+		}
+		j = i
+	}
+	return inside
+}
+
+// normalizeLon 把经度规范到 [-180, 180]
+func normalizeLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// InitialBearing 从点1到点2的大圆初始方位角，度，[0, 360)，正北为0
+func InitialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(x float64) float64 { return x * math.Pi / 180 }
+	la1, la2 := toRad(lat1), toRad(lat2)
+	dLon := toRad(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(la2)
+	x := math.Cos(la1)*math.Sin(la2) - math.Sin(la1)*math.Cos(la2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
 // GetDataDir 返回默认数据目录
 func GetDataDir() string { return filepath.Join(".", "go", "data") }
 
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+const (
+	benchPoints  = 150000
+	benchQueries = 100000
+)
+
+func benchmarkTree(b *testing.B, tree rgeocoder.KDTreeInterface, queries []rgeocoder.Coordinate) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := tree.Query(queries, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(queries)), "ns/point")
+}
+
+func BenchmarkQuerySingleThreaded(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	tree := rgeocoder.NewKDTree(randomCoords(r, benchPoints, -90, 90), rgeocoder.DistanceHaversine)
+	benchmarkTree(b, tree, randomCoords(r, benchQueries, -90, 90))
+}
+
+func BenchmarkQueryMultiThreaded(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	tree := rgeocoder.NewKDTreeMP(randomCoords(r, benchPoints, -90, 90), 0, rgeocoder.DistanceHaversine)
+	defer tree.Close()
+	benchmarkTree(b, tree, randomCoords(r, benchQueries, -90, 90))
+}
+
+func BenchmarkQueryFlat(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	tree := rgeocoder.NewFlatKDTree(randomCoords(r, benchPoints, -90, 90), rgeocoder.DistanceHaversine)
+	benchmarkTree(b, tree, randomCoords(r, benchQueries, -90, 90))
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// geoLine 生成 GeoNames 19 列格式的一行
+func geoLine(id int, name string, lat, lon float64, class, code, cc, a1, a2 string, pop int, date string) string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return strings.Join([]string{strconv.Itoa(id), name, name, "", f(lat), f(lon), class, code, cc, "", a1, a2, "", "",
+		strconv.Itoa(pop), "", "", "", date}, "\t")
+}
+
+func readDataset(t *testing.T, dir string) map[string][]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, "rg_cities1000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string][]string)
+	for _, row := range rows[1:] {
+		byName[row[2]] = row
+	}
+	return byName
+}
+
+func TestApplyDiff(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range rawGeoNamesFiles(t) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &rgeocoder.Config{DataDir: dir, DownloadURLs: rgeocoder.DefaultURLs}
+	p := rgeocoder.NewDataProcessor(cfg)
+	if err := p.ProcessGeoNamesData(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := rgeocoder.LoadDiffState(dir, rgeocoder.Cities1000)
+	if err != nil || state.LastApplied != "2023-01-12" {
+		t.Fatalf("unexpected base state %+v %v", state, err)
+	}
+
+	day := time.Date(2023, 1, 13, 0, 0, 0, 0, time.UTC)
+	mods, dels := rgeocoder.DiffFiles(day)
+	modsTxt := strings.Join([]string{
+		geoLine(2643743, "City of London", 51.50853, -0.12574, "P", "PPLC", "GB", "ENG", "GLA", 8961989, "2023-01-13"),
+		geoLine(2988507, "Paris", 48.85341, 2.3488, "P", "PPLC", "FR", "11", "75", 2138551, "2023-01-13"),
+		geoLine(1273874, "Kochi", 9.93988, 76.26022, "P", "PPL", "IN", "13", "594", 900, "2023-01-13"),
+		geoLine(111, "Hamlet", 10, 10, "P", "PPL", "IN", "13", "", 200, "2023-01-13"),
+		geoLine(222, "Seat", 11, 11, "P", "PPLA3", "IN", "13", "", 0, "2023-01-13"),
+		geoLine(333, "Peak", 12, 12, "T", "MT", "IN", "", "", 5000, "2023-01-13"),
+	}, "\n") + "\n"
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(mods, modsTxt)
+	write(dels, "5391959\tSan Francisco\tduplicate\n")
+
+	rec, err := p.ApplyDiff(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Updated != 1 || rec.Added != 2 || rec.Removed != 2 || rec.Skipped != 2 || rec.Rows != 3 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	rows := readDataset(t, dir)
+	if got := strings.Join(rows["City of London"], ","); got != "51.50853,-0.12574,City of London,England,Greater London,GB,2643743,8961989,P,PPLC,,0,ENG,GLA" {
+		t.Fatalf("modified row not rejoined: %s", got)
+	}
+	if _, ok := rows["Paris"]; !ok || len(rows) != 3 || rows["Kochi"] != nil || rows["San Francisco"] != nil {
+		t.Fatalf("unexpected dataset: %v", rows)
+	}
+
+	// 重复或跳过日期
+	if _, err := p.ApplyDiff(day); !errors.Is(err, rgeocoder.ErrDiffApplied) {
+		t.Fatalf("expected ErrDiffApplied, got %v", err)
+	}
+	if _, err := p.ApplyDiff(day.AddDate(0, 0, 2)); err == nil || !strings.Contains(err.Error(), "next is 2023-01-14") {
+		t.Fatalf("expected order error, got %v", err)
+	}
+
+	// 从镜像下载并依次应用剩余的增量
+	next := day.AddDate(0, 0, 1)
+	nextMods, nextDels := rgeocoder.DiffFiles(next)
+	m := newMirror(map[string][]byte{
+		nextMods: []byte(geoLine(2988507, "Paris", 48.85341, 2.3488, "P", "PPLC", "FR", "11", "75", 2138551, "2023-01-14") + "\n"),
+		nextDels: []byte("111\tHamlet\tnot in dataset\n222\tSeat\tduplicate\n"),
+	})
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	applied, err := p.ApplyPendingDiffs(newTestDownloader(t, srv, dir), next)
+	if err != nil || len(applied) != 1 || applied[0].Updated != 1 || applied[0].Removed != 1 || applied[0].Rows != 2 {
+		t.Fatalf("unexpected pending result %+v %v", applied, err)
+	}
+	state, err = rgeocoder.LoadDiffState(dir, rgeocoder.Cities1000)
+	if err != nil || state.LastApplied != "2023-01-14" || len(state.History) != 2 {
+		t.Fatalf("unexpected state %+v %v", state, err)
+	}
+
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 48.8, Lon: 2.3})
+	if err != nil || loc.Name != "Paris" || rg.Len() != 2 {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+}
+
+func TestApplyDiffNewRecordRemovedSameDay(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range rawGeoNamesFiles(t) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &rgeocoder.Config{DataDir: dir, DownloadURLs: rgeocoder.DefaultURLs}
+	p := rgeocoder.NewDataProcessor(cfg)
+	if err := p.ProcessGeoNamesData(); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2023, 1, 13, 0, 0, 0, 0, time.UTC)
+	mods, dels := rgeocoder.DiffFiles(day)
+	// 不在数据集中的记录：444 先收录后降到阈值以下，555 收录后被删除，666 收录、降级后又收录
+	modsTxt := strings.Join([]string{
+		geoLine(444, "Town", 10, 10, "P", "PPL", "IN", "13", "", 5000, "2023-01-13"),
+		geoLine(555, "Village", 11, 11, "P", "PPL", "IN", "13", "", 5000, "2023-01-13"),
+		geoLine(666, "Suburb", 12, 12, "P", "PPL", "IN", "13", "", 5000, "2023-01-13"),
+		geoLine(444, "Town", 10, 10, "P", "PPL", "IN", "13", "", 200, "2023-01-13"),
+		geoLine(666, "Suburb", 12, 12, "P", "PPL", "IN", "13", "", 200, "2023-01-13"),
+		geoLine(666, "Suburb", 12, 12, "P", "PPL", "IN", "13", "", 6000, "2023-01-13"),
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, mods), []byte(modsTxt), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, dels), []byte("555\tVillage\tduplicate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := p.ApplyDiff(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Added != 1 || rec.Removed != 0 || rec.Updated != 0 || rec.Skipped != 0 || rec.Rows != 4 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	rows := readDataset(t, dir)
+	if rows["Suburb"] == nil || rows["Suburb"][7] != "6000" || rows["Town"] != nil || rows["Village"] != nil {
+		t.Fatalf("unexpected dataset: %v", rows)
+	}
+}
@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// mirror 模拟 GeoNames 下载站，支持 Range 与 ETag 条件请求并记录请求
+type mirror struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	requests    []string
+	ranges      []string
+	conditional []string       // 带 If-None-Match 的请求
+	failures    map[string]int // 前 N 次返回 503
+}
+
+func newMirror(files map[string][]byte) *mirror {
+	return &mirror{files: files, failures: map[string]int{}}
+}
+
+func (m *mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/dump/")
+	m.mu.Lock()
+	m.requests = append(m.requests, name)
+	m.ranges = append(m.ranges, r.Header.Get("Range"))
+	if r.Header.Get("If-None-Match") != "" {
+		m.conditional = append(m.conditional, name)
+	}
+	fail := m.failures[name] > 0
+	if fail {
+		m.failures[name]--
+	}
+	data, ok := m.files[name]
+	m.mu.Unlock()
+	if fail {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%x\"", sha256.Sum256(data)))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+func newTestDownloader(t *testing.T, srv *httptest.Server, dir string) *rgeocoder.Downloader {
+	t.Helper()
+	cfg := &rgeocoder.Config{DataDir: dir, DownloadURLs: rgeocoder.DefaultURLs}
+	cfg.DownloadURLs.BaseURL = srv.URL + "/dump/"
+	d := rgeocoder.NewDownloader(cfg)
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func sampleFiles() map[string][]byte {
+	return map[string][]byte{
+		"cities1000.zip":       bytes.Repeat([]byte("zipdata-"), 4096),
+		"admin1CodesASCII.txt": []byte("US.CA\tCalifornia\tCalifornia\t5332921\n"),
+		"admin2Codes.txt":      []byte("US.CA.075\tSan Francisco\tSan Francisco\t5391997\n"),
+	}
+}
+
+func TestDownloadRequired(t *testing.T) {
+	m := newMirror(sampleFiles())
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	dir := t.TempDir()
+	d := newTestDownloader(t, srv, dir)
+
+	if err := d.DownloadRequired(); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	for name, want := range m.files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s content mismatch", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".part")); !os.IsNotExist(err) {
+			t.Fatalf("%s.part left behind", name)
+		}
+	}
+
+	// 再次调用应全部跳过
+	n := len(m.requests)
+	if err := d.DownloadRequired(); err != nil {
+		t.Fatalf("second download failed: %v", err)
+	}
+	if len(m.requests) != n {
+		t.Fatalf("expected no new requests, got %d", len(m.requests)-n)
+	}
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	files := sampleFiles()
+	m := newMirror(files)
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	dir := t.TempDir()
+	d := newTestDownloader(t, srv, dir)
+
+	full := files["cities1000.zip"]
+	half := len(full) / 2
+	if err := os.WriteFile(filepath.Join(dir, "cities1000.zip.part"), full[:half], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DownloadFile("cities1000.zip"); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "cities1000.zip"))
+	if !bytes.Equal(got, full) {
+		t.Fatalf("resumed content mismatch: %d bytes", len(got))
+	}
+	if want := "bytes=" + strconv.Itoa(half) + "-"; m.ranges[0] != want {
+		t.Fatalf("expected Range %q, got %q", want, m.ranges[0])
+	}
+}
+
+func TestDownloadRetriesTransientErrors(t *testing.T) {
+	m := newMirror(sampleFiles())
+	m.failures["admin1CodesASCII.txt"] = 2
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	d := newTestDownloader(t, srv, t.TempDir())
+
+	if err := d.DownloadFile("admin1CodesASCII.txt"); err != nil {
+		t.Fatalf("expected success after retries: %v", err)
+	}
+	if len(m.requests) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(m.requests))
+	}
+}
+
+func TestDownloadNotFoundIsNotRetried(t *testing.T) {
+	m := newMirror(map[string][]byte{})
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	d := newTestDownloader(t, srv, t.TempDir())
+
+	if err := d.DownloadFile("missing.txt"); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+	if len(m.requests) != 1 {
+		t.Fatalf("404 should not be retried, got %d attempts", len(m.requests))
+	}
+}
+
+func TestDownloadIfModified(t *testing.T) {
+	files := sampleFiles()
+	m := newMirror(files)
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	dir, staging := t.TempDir(), t.TempDir()
+	if err := newTestDownloader(t, srv, dir).DownloadFile("admin1CodesASCII.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "admin1CodesASCII.txt.etag")); err != nil {
+		t.Fatalf("etag not saved: %v", err)
+	}
+	ref := filepath.Join(dir, "admin1CodesASCII.txt")
+	d := newTestDownloader(t, srv, staging)
+
+	// 未变化：304，不写入文件
+	modified, err := d.DownloadIfModified(context.Background(), "admin1CodesASCII.txt", ref)
+	if err != nil || modified {
+		t.Fatalf("expected not modified, got %v %v", modified, err)
+	}
+	if _, err := os.Stat(filepath.Join(staging, "admin1CodesASCII.txt")); !os.IsNotExist(err) {
+		t.Fatalf("304 response wrote a file: %v", err)
+	}
+
+	// 已变化：下载新内容
+	m.mu.Lock()
+	m.files["admin1CodesASCII.txt"] = []byte("US.NY\tNew York\tNew York\t5128638\n")
+	m.mu.Unlock()
+	modified, err = d.DownloadIfModified(context.Background(), "admin1CodesASCII.txt", ref)
+	if err != nil || !modified {
+		t.Fatalf("expected download, got %v %v", modified, err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(staging, "admin1CodesASCII.txt")); !strings.Contains(string(got), "New York") {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if len(m.conditional) != 2 {
+		t.Fatalf("expected 2 conditional requests, got %v", m.conditional)
+	}
+}
+
+func TestDownloadRestartsWhenPartCannotResume(t *testing.T) {
+	full := sampleFiles()["cities1000.zip"]
+	for _, tc := range []struct {
+		name    string
+		part    []byte
+		handler func(m *mirror) http.Handler
+	}{
+		// 远端文件变短：Range 起点超出文件，416
+		{"range not satisfiable", bytes.Repeat([]byte("x"), len(full)+10), func(m *mirror) http.Handler { return m }},
+		// 206 的 Content-Range 起点与请求的不一致
+		{"mismatched content range", full[:100], func(m *mirror) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					m.mu.Lock()
+					m.ranges = append(m.ranges, r.Header.Get("Range"))
+					m.mu.Unlock()
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(full)-1, len(full)))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(full)
+					return
+				}
+				m.ServeHTTP(w, r)
+			})
+		}},
+		// 无法解析的 Content-Range
+		{"unparsable content range", full[:100], func(m *mirror) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					m.mu.Lock()
+					m.ranges = append(m.ranges, r.Header.Get("Range"))
+					m.mu.Unlock()
+					w.Header().Set("Content-Range", "bytes */*")
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(full[100:])
+					return
+				}
+				m.ServeHTTP(w, r)
+			})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMirror(sampleFiles())
+			srv := httptest.NewServer(tc.handler(m))
+			defer srv.Close()
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "cities1000.zip.part"), tc.part, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			d := newTestDownloader(t, srv, dir)
+			d.MaxRetries = 0
+			if err := d.DownloadFile("cities1000.zip"); err != nil {
+				t.Fatalf("expected restart from offset 0, got %v", err)
+			}
+			got, _ := os.ReadFile(filepath.Join(dir, "cities1000.zip"))
+			if !bytes.Equal(got, full) {
+				t.Fatalf("restarted content mismatch: %d bytes", len(got))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "cities1000.zip.part")); !os.IsNotExist(err) {
+				t.Fatalf("stale .part left behind: %v", err)
+			}
+			if n := len(m.ranges); n != 2 || m.ranges[0] == "" || m.ranges[1] != "" {
+				t.Fatalf("expected a Range request followed by a full request, got %q", m.ranges)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+//go:build rgeocoder_embed
+
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+func TestEmbeddedDatasetUsedWithoutDataDir(t *testing.T) {
+	if !rgeocoder.HasEmbeddedDataset() {
+		t.Fatal("rgeocoder_embed build has no embedded snapshot; run go generate ./pkg/rgeocoder")
+	}
+	dir := filepath.Join(t.TempDir(), "missing")
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	if _, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 51.5, Lon: -0.12}); err != nil {
+		t.Fatalf("embedded dataset query failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("data dir should not be created when using the embedded dataset")
+	}
+}
@@ -2,13 +2,20 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
 )
 
 func TestEmptyDatasetQuery(t *testing.T) {
-	rg, err := rgeocoder.NewRGeocoder()
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n"))
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -19,6 +26,24 @@ func TestEmptyDatasetQuery(t *testing.T) {
 	}
 }
 
+// skipIfEmbedded 编入了 cities1000 时，DataDir 中缺少该数据集会直接使用编入的数据而不下载生成，
+// 依赖下载生成路径的测试在 rgeocoder_embed 构建中跳过
+func skipIfEmbedded(t *testing.T) {
+	t.Helper()
+	if rgeocoder.HasEmbeddedDataset() {
+		t.Skip("cities1000 is embedded; the download path is not used")
+	}
+}
+
+func TestNewRGeocoderFailsWithoutDataset(t *testing.T) {
+	skipIfEmbedded(t)
+	// 数据集缺失且无法生成时返回错误，而不是空数据集
+	_, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(t.TempDir()), rgeocoder.WithAutoDownload(false))
+	if err == nil {
+		t.Fatalf("expected error when the dataset cannot be built")
+	}
+}
+
 func TestStreamDataset(t *testing.T) {
 	csvData := "lat,lon,name,admin1,admin2,cc\n37.78674,-122.39222,SampleCity,Region,Sub,US\n"
 	r := bytes.NewBufferString(csvData)
@@ -36,3 +61,193 @@ func TestStreamDataset(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryK(t *testing.T) {
+	f, err := os.Open("testdata/sample_cities.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rg, err := rgeocoder.NewRGeocoderWithStream(f)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	res, err := rg.QueryK([]rgeocoder.Coordinate{{Lat: 37.78674, Lon: -122.39222}}, 3)
+	if err != nil {
+		t.Fatalf("query k failed: %v", err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(res))
+	}
+	row := res[0]
+	if len(row) == 0 || row[0].Location.Name != "SampleCity" || row[0].Distance > 0.001 {
+		t.Fatalf("unexpected nearest: %+v", row)
+	}
+	for i := 1; i < len(row); i++ {
+		if row[i].Distance < row[i-1].Distance {
+			t.Fatalf("results not ordered: %+v", row)
+		}
+	}
+	if _, err := rg.QueryK([]rgeocoder.Coordinate{{Lat: 0, Lon: 0}}, 0); err == nil {
+		t.Fatalf("expected error for k=0")
+	}
+}
+
+func TestQueryRadius(t *testing.T) {
+	csvData := "lat,lon,name,admin1,admin2,cc\n" +
+		"51.50853,-0.12574,London,England,Greater London,GB\n" +
+		"51.75222,-1.25596,Oxford,England,Oxfordshire,GB\n" +
+		"52.20534,0.12181,Cambridge,England,Cambridgeshire,GB\n" +
+		"48.85341,2.3488,Paris,Ile-de-France,Paris,FR\n"
+	rg, err := rgeocoder.NewRGeocoderWithStream(bytes.NewBufferString(csvData))
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	depot := rgeocoder.Coordinate{Lat: 51.5074, Lon: -0.1278}
+	res, err := rg.QueryRadius(depot, 100, 0)
+	if err != nil {
+		t.Fatalf("query radius failed: %v", err)
+	}
+	var names []string
+	for _, n := range res {
+		names = append(names, n.Location.Name)
+	}
+	if strings.Join(names, ",") != "London,Cambridge,Oxford" {
+		t.Fatalf("unexpected towns within 100km: %v", names)
+	}
+	res, _ = rg.QueryRadius(depot, 100, 1)
+	if len(res) != 1 || res[0].Location.Name != "London" {
+		t.Fatalf("limit not applied: %+v", res)
+	}
+	if _, err := rg.QueryRadius(depot, -1, 0); err == nil {
+		t.Fatalf("expected error for negative radius")
+	}
+}
+
+func TestQueryBBoxAndPolygon(t *testing.T) {
+	csvData := "lat,lon,name,admin1,admin2,cc\n" +
+		"51.50853,-0.12574,London,England,Greater London,GB\n" +
+		"51.75222,-1.25596,Oxford,England,Oxfordshire,GB\n" +
+		"52.20534,0.12181,Cambridge,England,Cambridgeshire,GB\n" +
+		"48.85341,2.3488,Paris,Ile-de-France,Paris,FR\n" +
+		"-17.8,178.0,Suva,Central,,FJ\n" +
+		"-16.5,-179.9,Taveuni,Northern,,FJ\n"
+	rg, err := rgeocoder.NewRGeocoderWithStream(bytes.NewBufferString(csvData))
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	names := func(locs []rgeocoder.Location) string {
+		var out []string
+		for _, l := range locs {
+			out = append(out, l.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	locs, err := rg.QueryBBox(51, -1, 53, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(locs); got != "London,Cambridge" {
+		t.Fatalf("bbox: got %s", got)
+	}
+	locs, _ = rg.QueryBBox(-20, 170, -10, -170)
+	if got := names(locs); got != "Suva,Taveuni" {
+		t.Fatalf("antimeridian bbox: got %s", got)
+	}
+	if _, err := rg.QueryBBox(10, 0, -10, 1); err == nil {
+		t.Fatalf("expected error for minLat > maxLat")
+	}
+
+	// 五边形只包含 London 与 Oxford
+	locs, err = rg.QueryPolygon([]rgeocoder.Coordinate{{Lat: 51, Lon: -2}, {Lat: 52, Lon: -2}, {Lat: 52.1, Lon: -0.5}, {Lat: 51.6, Lon: 0}, {Lat: 51, Lon: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(locs); got != "London,Oxford" {
+		t.Fatalf("polygon: got %s", got)
+	}
+	// 跨反子午线的四边形
+	locs, err = rg.QueryPolygon([]rgeocoder.Coordinate{{Lat: -20, Lon: 177}, {Lat: -20, Lon: -179}, {Lat: -15, Lon: -179}, {Lat: -15, Lon: 177}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(locs); got != "Suva,Taveuni" {
+		t.Fatalf("antimeridian polygon: got %s", got)
+	}
+}
+
+func TestQueryDetailed(t *testing.T) {
+	for _, mode := range []rgeocoder.QueryMode{rgeocoder.SingleThreaded, rgeocoder.MultiThreaded} {
+		csvData := "lat,lon,name,admin1,admin2,cc\n51.50853,-0.12574,London,England,Greater London,GB\n48.85341,2.3488,Paris,Ile-de-France,Paris,FR\n"
+		rg, err := rgeocoder.NewRGeocoderWithStream(bytes.NewBufferString(csvData), rgeocoder.WithMode(mode))
+		if err != nil {
+			t.Fatalf("init failed: %v", err)
+		}
+		// 伦敦正北约 111km、巴黎附近
+		res, err := rg.QueryDetailed([]rgeocoder.Coordinate{{Lat: 52.50853, Lon: -0.12574}, {Lat: 48.85341, Lon: 2.3488}})
+		if err != nil {
+			t.Fatalf("query detailed failed: %v", err)
+		}
+		first := res[0]
+		if first.Location.Name != "London" || first.Index != 0 || first.Lat != 51.50853 || first.Lon != -0.12574 {
+			t.Fatalf("unexpected first result: %+v", first)
+		}
+		if math.Abs(first.Distance-111.19) > 0.1 {
+			t.Fatalf("unexpected distance: %f", first.Distance)
+		}
+		if math.Abs(first.Bearing-180) > 1e-6 {
+			t.Fatalf("expected due-south bearing, got %f", first.Bearing)
+		}
+		if res[1].Location.Name != "Paris" || res[1].Distance > 1e-9 {
+			t.Fatalf("unexpected second result: %+v", res[1])
+		}
+	}
+}
+
+func TestMaxDistanceNoMatch(t *testing.T) {
+	csvData := "lat,lon,name,admin1,admin2,cc\n-21.2,-175.2,Nukualofa,Tongatapu,,TO\n51.50853,-0.12574,London,England,Greater London,GB\n"
+	rg, err := rgeocoder.NewRGeocoderWithStream(bytes.NewBufferString(csvData), rgeocoder.WithMaxDistance(200))
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	pacific := rgeocoder.Coordinate{Lat: -10, Lon: -140}
+	if _, err := rg.QuerySingle(pacific); !errors.Is(err, rgeocoder.ErrNoMatch) {
+		t.Fatalf("expected ErrNoMatch, got %v", err)
+	}
+	coords := []rgeocoder.Coordinate{pacific, {Lat: 51.5, Lon: -0.1}}
+	locs, err := rg.Query(coords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(locs[0], rgeocoder.Location{}) || locs[1].Name != "London" {
+		t.Fatalf("unexpected batch result: %+v", locs)
+	}
+	details, err := rg.QueryDetailed(coords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details[0].Matched || details[0].Index != -1 || !details[1].Matched {
+		t.Fatalf("unexpected match flags: %+v", details)
+	}
+	res, _ := rg.QueryK([]rgeocoder.Coordinate{{Lat: 51.5, Lon: -0.1}}, 2)
+	if len(res[0]) != 1 {
+		t.Fatalf("QueryK should drop neighbours beyond max distance: %+v", res[0])
+	}
+}
+
+func TestQueryContextDeadline(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(bytes.NewBufferString("lat,lon,name,admin1,admin2,cc\n1,1,A,,,XX\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := rg.QueryContext(ctx, []rgeocoder.Coordinate{{Lat: 1, Lon: 1}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if _, err := rg.QuerySingleContext(context.Background(), rgeocoder.Coordinate{Lat: 1, Lon: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+type depot struct {
+	ID       int
+	Capacity float64
+	Tags     []string
+}
+
+func TestIndexTypedPayload(t *testing.T) {
+	coords := []rgeocoder.Coordinate{{Lat: 51.5, Lon: -0.12}, {Lat: 48.85, Lon: 2.35}, {Lat: 52.52, Lon: 13.4}}
+	items := []depot{{ID: 1, Capacity: 10}, {ID: 2, Capacity: 20, Tags: []string{"cold"}}, {ID: 3, Capacity: 30}}
+	for _, mode := range []rgeocoder.QueryMode{rgeocoder.SingleThreaded, rgeocoder.MultiThreaded} {
+		ix, err := rgeocoder.NewIndex(coords, items, rgeocoder.WithMode(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ix.Close()
+
+		m, err := ix.QuerySingle(rgeocoder.Coordinate{Lat: 48.9, Lon: 2.4})
+		if err != nil || m.Item.ID != 2 || m.Index != 1 || m.Item.Tags[0] != "cold" || m.Distance > 10 {
+			t.Fatalf("unexpected nearest: %+v %v", m, err)
+		}
+		k, err := ix.QueryK([]rgeocoder.Coordinate{{Lat: 50, Lon: 0}}, 3)
+		if err != nil || len(k[0]) != 3 || k[0][0].Item.ID != 1 || k[0][1].Item.ID != 2 || k[0][2].Item.ID != 3 {
+			t.Fatalf("unexpected k-nearest: %+v %v", k, err)
+		}
+		r, err := ix.QueryRadius(rgeocoder.Coordinate{Lat: 50, Lon: 0}, 400, 0)
+		if err != nil || len(r) != 2 {
+			t.Fatalf("unexpected radius result: %+v %v", r, err)
+		}
+	}
+
+	if _, err := rgeocoder.NewIndex(coords, items[:2]); err == nil {
+		t.Fatalf("expected length mismatch error")
+	}
+	ix, err := rgeocoder.NewIndex(coords, items, rgeocoder.WithMaxDistance(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.QuerySingle(rgeocoder.Coordinate{Lat: 0, Lon: 0}); !errors.Is(err, rgeocoder.ErrNoMatch) {
+		t.Fatalf("expected ErrNoMatch, got %v", err)
+	}
+	batch, err := ix.Query([]rgeocoder.Coordinate{{Lat: 0, Lon: 0}, {Lat: 52.5, Lon: 13.4}})
+	if err != nil || batch[0].Index != -1 || batch[1].Item.ID != 3 {
+		t.Fatalf("unexpected batch result: %+v %v", batch, err)
+	}
+}
+
+func TestRGeocoderIsLocationIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(19))
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(randomCSV(r, 500, "city")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	queries := randomCoords(r, 50, -90, 90)
+	want, err := rg.QueryK(queries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, release, err := rg.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	got, err := ix.QueryK(queries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if got[i][j].Index != want[i][j].Index || got[i][j].Item.Name != want[i][j].Location.Name {
+				t.Fatalf("query %d: got %+v want %+v", i, got[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestIndexMutationsMatchBruteForce(t *testing.T) {
+	for name, threshold := range map[string]int{"manual": -1, "auto": 7} {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(20))
+			coords := randomCoords(r, 300, -90, 90)
+			labels := make([]string, len(coords))
+			for i := range labels {
+				labels[i] = fmt.Sprintf("base-%d", i)
+			}
+			ix, err := rgeocoder.NewIndex(coords, labels, rgeocoder.WithRebuildThreshold(threshold))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ix.Close()
+
+			type item struct {
+				coord rgeocoder.Coordinate
+				label string
+			}
+			live := make(map[int]item)
+			for i, c := range coords {
+				live[i] = item{c, labels[i]}
+			}
+			check := func() {
+				t.Helper()
+				if ix.Len() != len(live) {
+					t.Fatalf("len: got %d want %d", ix.Len(), len(live))
+				}
+				ids := make([]int, 0, len(live))
+				for id := range live {
+					ids = append(ids, id)
+				}
+				sort.Ints(ids)
+				pts := make([]rgeocoder.Coordinate, len(ids))
+				for i, id := range ids {
+					pts[i] = live[id].coord
+				}
+				for _, q := range randomCoords(r, 30, -90, 90) {
+					m, err := ix.QuerySingle(q)
+					j, _ := bruteForceNearest(pts, q)
+					if err != nil || m.Index != ids[j] || m.Item != live[ids[j]].label {
+						t.Fatalf("nearest %v: got %+v want id %d (%v)", q, m, ids[j], err)
+					}
+					within := 0
+					for _, p := range pts {
+						if rgeocoder.HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon) <= 1500 {
+							within++
+						}
+					}
+					if got, _ := ix.QueryRadius(q, 1500, 0); len(got) != within {
+						t.Fatalf("radius %v: got %d want %d", q, len(got), within)
+					}
+				}
+			}
+
+			order := make([]int, len(coords))
+			for i := range order {
+				order[i] = i
+			}
+			for step := 1; step <= 300; step++ {
+				switch op := r.Intn(3); {
+				case op == 0 || len(order) == 0:
+					c := randomCoords(r, 1, -90, 90)[0]
+					label := fmt.Sprintf("added-%d", step)
+					id, err := ix.Add(c, label)
+					if err != nil {
+						t.Fatal(err)
+					}
+					live[id] = item{c, label}
+					order = append(order, id)
+				case op == 1:
+					k := r.Intn(len(order))
+					id := order[k]
+					if err := ix.Remove(id); err != nil {
+						t.Fatal(err)
+					}
+					delete(live, id)
+					order = append(order[:k], order[k+1:]...)
+				default:
+					id := order[r.Intn(len(order))]
+					c := randomCoords(r, 1, -90, 90)[0]
+					label := fmt.Sprintf("updated-%d", step)
+					if err := ix.Update(id, c, label); err != nil {
+						t.Fatal(err)
+					}
+					live[id] = item{c, label}
+				}
+				if step%25 == 0 {
+					check()
+				}
+			}
+			ix.Rebuild()
+			check()
+
+			if err := ix.Remove(-1); !errors.Is(err, rgeocoder.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			id := order[0]
+			if err := ix.Remove(id); err != nil {
+				t.Fatal(err)
+			}
+			if err := ix.Update(id, rgeocoder.Coordinate{}, "gone"); !errors.Is(err, rgeocoder.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound after remove, got %v", err)
+			}
+			if _, _, ok := ix.Get(id); ok {
+				t.Fatalf("removed id still readable")
+			}
+		})
+	}
+}
+
+func TestRGeocoderConcurrentMutations(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(randomCSV(r, 2000, "city")),
+		rgeocoder.WithRebuildThreshold(16), rgeocoder.WithMaxWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	queries := randomCoords(r, 300, -90, 90)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := rg.QueryDetailed(queries); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := rg.QueryK(queries[:10], 5); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := rg.QueryRadius(queries[0], 800, 10); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	added := make([]int, 0, 200)
+	for i := 0; i < 200; i++ {
+		c := rgeocoder.Coordinate{Lat: float64(i%170) - 85, Lon: float64(i) - 100}
+		id, err := rg.Add(c, rgeocoder.Location{Name: fmt.Sprintf("store-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, id)
+		if i%3 == 0 {
+			if err := rg.Remove(i * 5); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// 刚新增的点即为自身位置的最近邻
+	for i, id := range added {
+		c := rgeocoder.Coordinate{Lat: float64(i%170) - 85, Lon: float64(i) - 100}
+		res, err := rg.QueryDetailed([]rgeocoder.Coordinate{c})
+		if err != nil || res[0].Index != id || res[0].Location.Name != fmt.Sprintf("store-%d", i) {
+			t.Fatalf("added %d: got %+v (%v)", i, res[0], err)
+		}
+	}
+}
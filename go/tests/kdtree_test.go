@@ -0,0 +1,413 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+func randomCoords(r *rand.Rand, n int, minLat, maxLat float64) []rgeocoder.Coordinate {
+	out := make([]rgeocoder.Coordinate, n)
+	for i := range out {
+		out[i] = rgeocoder.Coordinate{
+			Lat: minLat + r.Float64()*(maxLat-minLat),
+			Lon: -180 + r.Float64()*360,
+		}
+	}
+	return out
+}
+
+// bruteForceNearest 线性扫描求球面最近点
+func bruteForceNearest(points []rgeocoder.Coordinate, q rgeocoder.Coordinate) (int, float64) {
+	best, bestDist := -1, math.MaxFloat64
+	for i, p := range points {
+		if d := rgeocoder.HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best, bestDist
+}
+
+func TestKDTreeHaversineMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	cases := []struct {
+		name           string
+		minLat, maxLat float64
+	}{
+		{"global", -90, 90},
+		{"arctic", 60, 90},
+		{"antarctic", -90, -70},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			points := randomCoords(r, 2000, tc.minLat, tc.maxLat)
+			queries := randomCoords(r, 500, tc.minLat, tc.maxLat)
+			tree := rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine)
+			dists, indices, err := tree.Query(queries, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, q := range queries {
+				_, wantDist := bruteForceNearest(points, q)
+				if math.Abs(dists[i]-wantDist) > 1e-9 {
+					t.Fatalf("query %v: got idx=%d dist=%f, want dist=%f", q, indices[i], dists[i], wantDist)
+				}
+			}
+		})
+	}
+}
+
+func TestKDTreeHaversineNearPoleAndAntimeridian(t *testing.T) {
+	points := []rgeocoder.Coordinate{
+		{Lat: 88.0, Lon: 0.0},      // 0: 同经度但远
+		{Lat: 89.5, Lon: 179.0},    // 1: 跨过极点更近
+		{Lat: 0.0, Lon: 179.0},     // 2
+		{Lat: 0.0, Lon: -179.95},   // 3: 跨反子午线更近
+		{Lat: 70.0, Lon: 10.0},     // 4
+		{Lat: 70.0, Lon: 40.0},     // 5
+		{Lat: 69.0, Lon: 25.0},     // 6: 高纬下经度差代价小
+		{Lat: -89.9, Lon: -120.0},  // 7
+		{Lat: -85.0, Lon: 60.0},    // 8
+		{Lat: 45.0, Lon: -179.999}, // 9
+	}
+	tree := rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine)
+	queries := []rgeocoder.Coordinate{
+		{Lat: 89.9, Lon: 0.0},
+		{Lat: 0.0, Lon: 179.95},
+		{Lat: 70.0, Lon: 25.0},
+		{Lat: -89.95, Lon: 60.0},
+		{Lat: 45.0, Lon: 179.999},
+	}
+	_, indices, err := tree.Query(queries, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, q := range queries {
+		want, _ := bruteForceNearest(points, q)
+		if indices[i] != want {
+			t.Fatalf("query %v: got %d want %d", q, indices[i], want)
+		}
+	}
+}
+
+func TestKDTreeEuclideanDegrees(t *testing.T) {
+	points := []rgeocoder.Coordinate{{Lat: 0, Lon: 179}, {Lat: 0, Lon: -179.9}}
+	tree := rgeocoder.NewKDTree(points, rgeocoder.DistanceEuclideanDegrees)
+	_, indices, _ := tree.Query([]rgeocoder.Coordinate{{Lat: 0, Lon: 179.9}}, 1)
+	// 平面模式不考虑经度环绕（与Python版本行为一致）
+	if indices[0] != 0 {
+		t.Fatalf("expected planar nearest 0, got %d", indices[0])
+	}
+}
+
+func TestGeodeticToECEF(t *testing.T) {
+	out := rgeocoder.GeodeticToECEF([]rgeocoder.Coordinate{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 90}, {Lat: 90, Lon: 0}})
+	want := [][]float64{
+		{rgeocoder.WGS84MajorAxis, 0, 0},
+		{0, rgeocoder.WGS84MajorAxis, 0},
+		{0, 0, 6356.752314245},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(out[i][j]-want[i][j]) > 1e-6 {
+				t.Fatalf("point %d axis %d: got %f want %f", i, j, out[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestECEFTreeMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	points := randomCoords(r, 2000, -90, 90)
+	queries := append(randomCoords(r, 300, -90, 90),
+		rgeocoder.Coordinate{Lat: 0, Lon: 179.999},
+		rgeocoder.Coordinate{Lat: 89.999, Lon: -45},
+	)
+	ecef := rgeocoder.GeodeticToECEF(points)
+	tree := rgeocoder.NewECEFTree(points)
+	dists, _, err := tree.Query(queries, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, q := range rgeocoder.GeodeticToECEF(queries) {
+		want := math.MaxFloat64
+		for _, p := range ecef {
+			want = math.Min(want, math.Sqrt((q[0]-p[0])*(q[0]-p[0])+(q[1]-p[1])*(q[1]-p[1])+(q[2]-p[2])*(q[2]-p[2])))
+		}
+		if math.Abs(dists[i]-want) > 1e-9 {
+			t.Fatalf("query %v: got %f want %f", queries[i], dists[i], want)
+		}
+	}
+}
+
+func TestECEFModeAcrossAntimeridian(t *testing.T) {
+	csvData := "lat,lon,name,admin1,admin2,cc\n-16.5,179.9,East,,,FJ\n-16.5,-179.95,West,,,FJ\n-16.5,178.0,Far,,,FJ\n"
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(csvData), rgeocoder.WithDistanceMode(rgeocoder.DistanceECEF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: -16.5, Lon: -179.99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Name != "West" {
+		t.Fatalf("expected West, got %+v", loc)
+	}
+	loc, _ = rg.QuerySingle(rgeocoder.Coordinate{Lat: -16.5, Lon: 179.92})
+	if loc.Name != "East" {
+		t.Fatalf("expected East, got %+v", loc)
+	}
+}
+
+func TestKNearestMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	points := randomCoords(r, 1500, -90, 90)
+	queries := randomCoords(r, 100, -90, 90)
+	const k = 5
+	trees := map[string]rgeocoder.KDTreeInterface{
+		"kdtree":    rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine),
+		"kdtree_mp": rgeocoder.NewKDTreeMP(points, 4, rgeocoder.DistanceHaversine),
+		"flat":      rgeocoder.NewFlatKDTree(points, rgeocoder.DistanceHaversine),
+	}
+	for name, tree := range trees {
+		t.Run(name, func(t *testing.T) {
+			dists, indices, err := tree.Query(queries, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dists) != len(queries)*k || len(indices) != len(queries)*k {
+				t.Fatalf("unexpected result length %d/%d", len(dists), len(indices))
+			}
+			for i, q := range queries {
+				all := make([]float64, len(points))
+				for j, p := range points {
+					all[j] = rgeocoder.HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon)
+				}
+				sort.Float64s(all)
+				for j := 0; j < k; j++ {
+					if math.Abs(dists[i*k+j]-all[j]) > 1e-9 {
+						t.Fatalf("query %d neighbour %d: got %f want %f", i, j, dists[i*k+j], all[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKNearestPadsWhenFewerPoints(t *testing.T) {
+	tree := rgeocoder.NewKDTree([]rgeocoder.Coordinate{{Lat: 1, Lon: 1}, {Lat: 2, Lon: 2}}, rgeocoder.DistanceHaversine)
+	dists, indices, err := tree.Query([]rgeocoder.Coordinate{{Lat: 0, Lon: 0}}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indices[0] != 0 || indices[1] != 1 || indices[2] != -1 || !math.IsInf(dists[2], 1) {
+		t.Fatalf("unexpected padding: %v %v", dists, indices)
+	}
+}
+
+func TestQueryRadiusMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(23))
+	points := randomCoords(r, 3000, -90, 90)
+	queries := append(randomCoords(r, 50, -90, 90),
+		rgeocoder.Coordinate{Lat: 89.8, Lon: 10},
+		rgeocoder.Coordinate{Lat: 5, Lon: 179.9},
+	)
+	trees := map[string]rgeocoder.RadiusQuerier{
+		"haversine": rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine),
+		"euclidean": rgeocoder.NewKDTree(points, rgeocoder.DistanceEuclideanDegrees),
+		"ecef":      rgeocoder.NewECEFTree(points),
+		"mp":        rgeocoder.NewKDTreeMP(points, 2, rgeocoder.DistanceHaversine),
+		"flat":      rgeocoder.NewFlatKDTree(points, rgeocoder.DistanceHaversine),
+		"flat_ecef": rgeocoder.NewFlatKDTree(points, rgeocoder.DistanceECEF),
+	}
+	const radius = 800.0
+	for name, tree := range trees {
+		t.Run(name, func(t *testing.T) {
+			for _, q := range queries {
+				var want []float64
+				for _, p := range points {
+					if d := rgeocoder.HaversineDistance(q.Lat, q.Lon, p.Lat, p.Lon); d <= radius {
+						want = append(want, d)
+					}
+				}
+				sort.Float64s(want)
+				dists, indices := tree.QueryRadius(q, radius, 0)
+				if len(dists) != len(want) || len(indices) != len(want) {
+					t.Fatalf("query %v: got %d results want %d", q, len(dists), len(want))
+				}
+				for i := range want {
+					if math.Abs(dists[i]-want[i]) > 1e-9 {
+						t.Fatalf("query %v result %d: got %f want %f", q, i, dists[i], want[i])
+					}
+				}
+				limited, _ := tree.QueryRadius(q, radius, 3)
+				if len(limited) != min(3, len(want)) {
+					t.Fatalf("limit not applied: got %d", len(limited))
+				}
+				for i := range limited {
+					if limited[i] != dists[i] {
+						t.Fatalf("limited results should be the nearest ones")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQueryBBoxMatchesScan(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	points := randomCoords(r, 3000, -90, 90)
+	trees := map[string]rgeocoder.RangeQuerier{
+		"kdtree":    rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine),
+		"flat":      rgeocoder.NewFlatKDTree(points, rgeocoder.DistanceHaversine),
+		"flat_ecef": rgeocoder.NewFlatKDTree(points, rgeocoder.DistanceECEF),
+	}
+	boxes := [][4]float64{
+		{10, 20, 40, 60},
+		{-30, 170, 10, -170}, // 跨越反子午线
+		{-90, -180, 90, 180},
+	}
+	for _, b := range boxes {
+		var want []int
+		for i, p := range points {
+			inLon := p.Lon >= b[1] && p.Lon <= b[3]
+			if b[1] > b[3] {
+				inLon = p.Lon >= b[1] || p.Lon <= b[3]
+			}
+			if p.Lat >= b[0] && p.Lat <= b[2] && inLon {
+				want = append(want, i)
+			}
+		}
+		for name, tree := range trees {
+			got := tree.QueryBBox(b[0], b[1], b[2], b[3])
+			if len(got) != len(want) {
+				t.Fatalf("%s bbox %v: got %d points want %d", name, b, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("%s bbox %v: index mismatch at %d", name, b, i)
+				}
+			}
+		}
+	}
+}
+
+func TestFlatKDTreeMatchesPointerTrees(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	points := randomCoords(r, 4000, -90, 90)
+	// 大量重复点与聚集点，考验 quickselect 的三路划分
+	for i := 0; i < 500; i++ {
+		points = append(points, rgeocoder.Coordinate{Lat: 48.85, Lon: 2.35})
+		points = append(points, rgeocoder.Coordinate{Lat: 48.85 + r.Float64()*0.01, Lon: 2.35})
+	}
+	queries := append(randomCoords(r, 300, -90, 90),
+		rgeocoder.Coordinate{Lat: 48.85, Lon: 2.35},
+		rgeocoder.Coordinate{Lat: 89.9, Lon: -45},
+		rgeocoder.Coordinate{Lat: -10, Lon: -179.99},
+	)
+	const k = 4
+	modes := map[string]rgeocoder.DistanceMode{
+		"haversine": rgeocoder.DistanceHaversine,
+		"euclidean": rgeocoder.DistanceEuclideanDegrees,
+		"ecef":      rgeocoder.DistanceECEF,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			var ref rgeocoder.KDTreeInterface = rgeocoder.NewKDTree(points, mode)
+			if mode == rgeocoder.DistanceECEF {
+				ref = rgeocoder.NewECEFTree(points)
+			}
+			wantD, _, err := ref.Query(queries, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotD, gotI, err := rgeocoder.NewFlatKDTree(points, mode).Query(queries, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[int]bool)
+			for i := range wantD {
+				if math.Abs(gotD[i]-wantD[i]) > 1e-9 {
+					t.Fatalf("result %d: got %f want %f", i, gotD[i], wantD[i])
+				}
+				if i%k == 0 {
+					clear(seen)
+				}
+				if gotI[i] < 0 || gotI[i] >= len(points) || seen[gotI[i]] {
+					t.Fatalf("result %d: bad or duplicate index %d", i, gotI[i])
+				}
+				seen[gotI[i]] = true
+			}
+		})
+	}
+
+	empty := rgeocoder.NewFlatKDTree(nil, rgeocoder.DistanceHaversine)
+	dists, indices, err := empty.Query([]rgeocoder.Coordinate{{Lat: 1, Lon: 1}}, 2)
+	if err != nil || indices[0] != -1 || !math.IsInf(dists[1], 1) {
+		t.Fatalf("empty tree should pad: %v %v %v", dists, indices, err)
+	}
+}
+
+func TestKDTreeMPMatchesSingleThreaded(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	points := randomCoords(r, 5000, -90, 90)
+	queries := randomCoords(r, 20000, -90, 90)
+	single := rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine)
+	mp := rgeocoder.NewKDTreeMP(points, 4, rgeocoder.DistanceHaversine)
+	defer mp.Close()
+
+	wantD, wantI, _ := single.Query(queries, 2)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gotD, gotI, err := mp.Query(queries, 2)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := range wantI {
+				if gotI[i] != wantI[i] || gotD[i] != wantD[i] {
+					t.Errorf("result %d: got (%d, %f) want (%d, %f)", i, gotI[i], gotD[i], wantI[i], wantD[i])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Close 之后仍可查询（退化为顺序执行）
+	mp.Close()
+	_, gotI, err := mp.Query(queries, 2)
+	if err != nil || gotI[len(gotI)-1] != wantI[len(wantI)-1] {
+		t.Fatalf("query after close failed: %v", err)
+	}
+}
+
+func TestQueryContextCancelled(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	points := randomCoords(r, 1000, -90, 90)
+	queries := randomCoords(r, 10000, -90, 90)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mp := rgeocoder.NewKDTreeMP(points, 4, rgeocoder.DistanceHaversine)
+	defer mp.Close()
+	trees := map[string]rgeocoder.KDTreeInterface{
+		"kdtree":    rgeocoder.NewKDTree(points, rgeocoder.DistanceHaversine),
+		"ecef":      rgeocoder.NewECEFTree(points),
+		"kdtree_mp": mp,
+	}
+	for name, tree := range trees {
+		if _, _, err := tree.QueryContext(ctx, queries, 1); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: expected context.Canceled, got %v", name, err)
+		}
+	}
+}
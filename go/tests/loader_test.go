@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+const loaderCSV = "lat,lon,name,admin1,admin2,cc\n" +
+	"51.50853,-0.12574,London,England,Greater London,GB\n" +
+	"48.85341,2.3488,Paris,Ile-de-France,Paris,FR\n"
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+func TestLoadFromFSDetectsCompression(t *testing.T) {
+	fsys := fstest.MapFS{
+		"plain.csv":        {Data: []byte(loaderCSV)},
+		"data.csv.gz":      {Data: gzipBytes(t, []byte(loaderCSV))},
+		"data.csv.zst":     {Data: zstdBytes(t, []byte(loaderCSV))},
+		"bundle.zip":       {Data: zipBytes(t, "cities/rg_cities1000.csv", loaderCSV)},
+		"nested.zip":       {Data: zipBytes(t, "rg.csv.gz", string(gzipBytes(t, []byte(loaderCSV))))},
+		"misnamed.bin":     {Data: zstdBytes(t, []byte(loaderCSV))},
+		"zip_gz_twice.zip": {Data: zipBytes(t, "x.csv", string(gzipBytes(t, gzipBytes(t, []byte(loaderCSV)))))},
+	}
+	loader := rgeocoder.NewDataLoader(&rgeocoder.Config{})
+	for name := range fsys {
+		t.Run(name, func(t *testing.T) {
+			coords, locs, err := loader.LoadFromFS(fsys, name)
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			if len(coords) != 2 || locs[0].Name != "London" || locs[1].CC != "FR" {
+				t.Fatalf("unexpected result: %v %+v", coords, locs)
+			}
+		})
+	}
+}
+
+func TestLoadFromFileCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rg.zip")
+	if err := os.WriteFile(path, zipBytes(t, "rg_cities1000.csv", loaderCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, locs, err := rgeocoder.NewDataLoader(&rgeocoder.Config{}).LoadFromFile(path)
+	if err != nil || len(locs) != 2 {
+		t.Fatalf("load zip file failed: %v %v", locs, err)
+	}
+}
+
+func TestNewRGeocoderWithDataFS(t *testing.T) {
+	fsys := fstest.MapFS{"rg_cities1000.csv.gz": {Data: gzipBytes(t, []byte(loaderCSV))}}
+	dir := filepath.Join(t.TempDir(), "unused")
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithDataFS(fsys, "rg_cities1000.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 48.8, Lon: 2.3})
+	if err != nil || loc.Name != "Paris" {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("data dir should not be touched when DataFS is set")
+	}
+	// 即使 DataFS 中缺少文件，也不应回退到默认数据目录或下载
+	missingDir := t.TempDir()
+	_, err = rgeocoder.NewRGeocoder(rgeocoder.WithDataFS(fsys, "missing.csv"),
+		rgeocoder.WithDataDir(missingDir), rgeocoder.WithAutoDownload(false))
+	if err == nil {
+		t.Fatalf("expected error for missing file in DataFS")
+	}
+	if entries, _ := os.ReadDir(missingDir); len(entries) != 0 {
+		t.Fatalf("data dir touched for a missing DataFS file: %v", entries)
+	}
+}
+
+func TestColumnMappingKeepsAttributes(t *testing.T) {
+	poi := "store_id,region_code,longitude,latitude,title,country\n" +
+		"S-001,R7,-0.12574,51.50853,Oxford Street,GB\n" +
+		"S-002,R9,2.3488,48.85341,Rivoli,FR\n"
+	mapping := rgeocoder.ColumnMapping{Lat: "latitude", Lon: "longitude", Name: "title", CC: "country"}
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(poi), rgeocoder.WithColumnMapping(mapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	want := rgeocoder.Location{
+		Lat: "48.85341", Lon: "2.3488", Name: "Rivoli", CC: "FR",
+		Attributes: map[string]string{"store_id": "S-002", "region_code": "R9"},
+	}
+	locs, err := rg.Query([]rgeocoder.Coordinate{{Lat: 48.9, Lon: 2.4}})
+	if err != nil || !reflect.DeepEqual(locs[0], want) {
+		t.Fatalf("got %+v want %+v (%v)", locs, want, err)
+	}
+	b, _ := json.Marshal(locs[0])
+	if !strings.Contains(string(b), `"attributes":{"region_code":"R9","store_id":"S-002"}`) {
+		t.Fatalf("attributes not serialised: %s", b)
+	}
+
+	// 快照与 mmap 保留 Attributes
+	path := filepath.Join(t.TempDir(), "poi.snap")
+	if err := rg.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	for name, open := range map[string]func(string, ...rgeocoder.Option) (*rgeocoder.RGeocoder, error){
+		"load": rgeocoder.LoadSnapshot,
+		"mmap": rgeocoder.OpenMapped,
+	} {
+		loaded, err := open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loaded.QuerySingle(rgeocoder.Coordinate{Lat: 48.9, Lon: 2.4})
+		loaded.Close()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %+v want %+v (%v)", name, got, want, err)
+		}
+	}
+}
+
+func TestColumnMappingErrors(t *testing.T) {
+	csvData := "y,x,title\n1,2,A\n"
+	cases := map[string]rgeocoder.ColumnMapping{
+		"missing lat":     {Lon: "x"},
+		"unknown column":  {Lat: "y", Lon: "x", Name: "name"},
+		"unknown lon col": {Lat: "y", Lon: "lng"},
+	}
+	for name, m := range cases {
+		if _, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(csvData), rgeocoder.WithColumnMapping(m)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+	// 默认仍要求标准表头
+	if _, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(csvData)); err == nil {
+		t.Fatalf("expected header error without mapping")
+	}
+}
+
+const dirtyCSV = "lat,lon,name,admin1,admin2,cc\n" +
+	"51.50853,-0.12574,London,England,Greater London,GB\n" +
+	"abc,2.3488,BadFloat,,,FR\n" +
+	"NaN,2.0,NotANumber,,,FR\n" +
+	"95.0,10.0,TooFarNorth,,,XX\n" +
+	"10.0,Inf,Infinite,,,XX\n" +
+	"1.0,2.0,Short\n" +
+	"51.50853,-0.12574,London,England,Greater London,GB\n" +
+	"48.85341,2.3488,Paris,Ile-de-France,Paris,FR\n"
+
+func TestLenientLoadReport(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(dirtyCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	report := rg.LoadReport()
+	if report == nil {
+		t.Fatalf("expected a load report")
+	}
+	if report.Rows != 8 || report.Loaded != 2 || report.Rejected != 6 {
+		t.Fatalf("unexpected counts: %s", report)
+	}
+	wantReasons := map[rgeocoder.RejectReason]int{
+		rgeocoder.RejectBadFloat:   1,
+		rgeocoder.RejectNaN:        1,
+		rgeocoder.RejectOutOfRange: 2,
+		rgeocoder.RejectFieldCount: 1,
+		rgeocoder.RejectDuplicate:  1,
+	}
+	if !reflect.DeepEqual(report.Reasons, wantReasons) {
+		t.Fatalf("reasons: got %v want %v", report.Reasons, wantReasons)
+	}
+	first := report.Samples[0]
+	if first.Line != 3 || first.Reason != rgeocoder.RejectBadFloat || first.Record[2] != "BadFloat" {
+		t.Fatalf("unexpected first sample: %+v", first)
+	}
+	if last := report.Samples[len(report.Samples)-1]; last.Line != 8 || last.Reason != rgeocoder.RejectDuplicate {
+		t.Fatalf("unexpected duplicate sample: %+v", last)
+	}
+}
+
+func TestDistinctRowsAreNotDuplicates(t *testing.T) {
+	// 字段拼接后相同、拆分位置不同的两行是不同的行
+	data := "lat,lon,name,admin1,admin2,cc\n" +
+		"1.0,2.0,\"a,b\",c,,XX\n" +
+		"1.0,2.0,a,\"b,c\",,XX\n" +
+		"1.0,2.0,a\x1fb,c,,XX\n" +
+		"1.0,2.0,a,b\x1fc,,XX\n"
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(data), rgeocoder.WithStrictLoad(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	if rg.Len() != 4 {
+		t.Fatalf("expected 4 locations, got %d", rg.Len())
+	}
+}
+
+func TestStrictLoadFailsOnFirstBadRow(t *testing.T) {
+	_, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(dirtyCSV), rgeocoder.WithStrictLoad(true))
+	var rowErr *rgeocoder.RowError
+	if !errors.As(err, &rowErr) {
+		t.Fatalf("expected RowError, got %v", err)
+	}
+	if rowErr.Line != 3 || rowErr.Reason != rgeocoder.RejectBadFloat {
+		t.Fatalf("unexpected row error: %+v", rowErr)
+	}
+
+	// 数据目录中的文件同样适用
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rg_cities1000.csv"), []byte(dirtyCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithStrictLoad(true)); !errors.As(err, &rowErr) {
+		t.Fatalf("expected RowError from NewRGeocoder, got %v", err)
+	}
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	if rg.LoadReport().Rejected != 6 {
+		t.Fatalf("unexpected report: %s", rg.LoadReport())
+	}
+}
+
+const extendedCSV = "lat,lon,name,admin1,admin2,cc,geonameid,population,feature_class,feature_code,timezone,elevation,admin1_code,admin2_code\n" +
+	"51.50853,-0.12574,London,England,Greater London,GB,2643743,8961989,P,PPLC,Europe/London,25,ENG,GLA\n" +
+	"48.85341,2.3488,Paris,Ile-de-France,Paris,FR,2988507,,P,PPLC,Europe/Paris,,11,75\n" +
+	"10,10,Broken,,,XX,12x,0,P,PPL,,0,,\n"
+
+func TestExtendedDatasetColumns(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(extendedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 51.5, Lon: -0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rgeocoder.Location{
+		Lat: "51.50853", Lon: "-0.12574", Name: "London", Admin1: "England", Admin2: "Greater London", CC: "GB",
+		GeoNameID: 2643743, Population: 8961989, FeatureClass: "P", FeatureCode: "PPLC",
+		Timezone: "Europe/London", Elevation: 25, Admin1Code: "ENG", Admin2Code: "GLA",
+	}
+	if !reflect.DeepEqual(loc, want) {
+		t.Fatalf("got %+v want %+v", loc, want)
+	}
+	paris, _ := rg.QuerySingle(rgeocoder.Coordinate{Lat: 48.8, Lon: 2.3})
+	if paris.GeoNameID != 2988507 || paris.Population != 0 || paris.Elevation != 0 || paris.Admin2Code != "75" {
+		t.Fatalf("unexpected empty-field handling: %+v", paris)
+	}
+	report := rg.LoadReport()
+	if report.Loaded != 2 || report.Reasons[rgeocoder.RejectBadInt] != 1 || !strings.Contains(report.Samples[0].Detail, "geonameid") {
+		t.Fatalf("unexpected report: %s", report)
+	}
+
+	// 旧的六列数据集照常加载，新字段为零值
+	legacy, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(loaderCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	old, err := legacy.QuerySingle(rgeocoder.Coordinate{Lat: 51.5, Lon: -0.1})
+	if err != nil || old.GeoNameID != 0 || old.Timezone != "" || old.Name == "" {
+		t.Fatalf("unexpected legacy result %+v %v", old, err)
+	}
+	if _, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc,geonameid\n1,1,A,,,XX,1\n")); err == nil {
+		t.Fatalf("expected error for partial header")
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// parityRow 对应 parity_random_100*.py --golden 写出的一行
+type parityRow struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Name   string  `json:"name"`
+	Admin1 string  `json:"admin1"`
+	Admin2 string  `json:"admin2"`
+	CC     string  `json:"cc"`
+}
+
+// parityMeta 黄金数据的来源，由 --golden 与数据一起写出（<golden>.meta.json）
+type parityMeta struct {
+	Generator       string `json:"generator"`
+	ReverseGeocoder string `json:"reverse_geocoder"` // 生成时的库版本，为空表示不是由库生成
+	Scipy           string `json:"scipy"`
+	Dataset         string `json:"dataset"`
+}
+
+func loadParityMeta(t *testing.T, golden string) parityMeta {
+	t.Helper()
+	path := strings.TrimSuffix(golden, ".jsonl") + ".meta.json"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("golden metadata not available (%v); generate with tests/parity_random_100*.py --golden", err)
+	}
+	var meta parityMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	return meta
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadParityGolden(t *testing.T, path string) []parityRow {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("golden file not available (%v); generate with tests/parity_random_100*.py --golden", err)
+	}
+	defer f.Close()
+	var rows []parityRow
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row parityRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("parse golden: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// parityKey 位置的可比较字段
+func parityKey(name, admin1, admin2, cc string) string {
+	return name + "\x00" + admin1 + "\x00" + admin2 + "\x00" + cc
+}
+
+// loadParityCoords 数据集中每个位置（按 parityKey）的坐标，同名位置都保留
+func loadParityCoords(t *testing.T, path string) map[string][]rgeocoder.Coordinate {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coords := make(map[string][]rgeocoder.Coordinate)
+	for _, r := range rows[1:] {
+		lat, _ := strconv.ParseFloat(r[0], 64)
+		lon, _ := strconv.ParseFloat(r[1], 64)
+		key := parityKey(r[2], r[3], r[4], r[5])
+		coords[key] = append(coords[key], rgeocoder.Coordinate{Lat: lat, Lon: lon})
+	}
+	return coords
+}
+
+// TestPythonParity 用 testdata 中的数据集（rg_cities1000.csv 每 100 行取 1 行）比对
+// tests/parity_random_100*.py --golden 生成的黄金输出，来源记录在 parity_golden.meta.json。
+// Python reverse_geocoder 在原始 (lat, lon) 上建 cKDTree（geodetic_in_ecef 从未被调用），
+// 因此只有 DistanceEuclideanDegrees 与它逐条一致。ECEF 不是 Python parity：这里只检查
+// ECEF 选出的不同位置在球面上确实更近。
+func TestPythonParity(t *testing.T) {
+	goldenPath := envOr("RG_PARITY_GOLDEN", "testdata/parity_golden.jsonl")
+	golden := loadParityGolden(t, goldenPath)
+	if meta := loadParityMeta(t, goldenPath); meta.ReverseGeocoder == "" {
+		t.Logf("WARNING: golden data was not produced by reverse_geocoder (%s); "+
+			"this only compares against an exact nearest-neighbour reference", meta.Generator)
+	} else {
+		t.Logf("golden data from reverse_geocoder %s (scipy %s)", meta.ReverseGeocoder, meta.Scipy)
+	}
+	dataset := envOr("RG_PARITY_DATASET", "testdata/parity_cities.csv")
+	coords := make([]rgeocoder.Coordinate, len(golden))
+	for i, g := range golden {
+		coords[i] = rgeocoder.Coordinate{Lat: g.Lat, Lon: g.Lon}
+	}
+
+	run := func(mode rgeocoder.DistanceMode) []rgeocoder.Location {
+		f, err := os.Open(dataset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		rg, err := rgeocoder.NewRGeocoderWithStream(f, rgeocoder.WithMode(rgeocoder.SingleThreaded), rgeocoder.WithDistanceMode(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rg.Close()
+		locs, err := rg.Query(coords)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locs
+	}
+	same := func(l rgeocoder.Location, g parityRow) bool {
+		return l.Name == g.Name && l.Admin1 == g.Admin1 && l.Admin2 == g.Admin2 && l.CC == g.CC
+	}
+
+	for i, l := range run(rgeocoder.DistanceEuclideanDegrees) {
+		if g := golden[i]; !same(l, g) {
+			b, _ := json.Marshal(l)
+			t.Fatalf("point %d (%v,%v): %s != %s/%s", i, g.Lat, g.Lon, b, g.Name, g.CC)
+		}
+	}
+
+	known := loadParityCoords(t, dataset)
+	mismatches := 0
+	for i, l := range run(rgeocoder.DistanceECEF) {
+		g := golden[i]
+		if same(l, g) {
+			continue
+		}
+		mismatches++
+		lat, _ := strconv.ParseFloat(l.Lat, 64)
+		lon, _ := strconv.ParseFloat(l.Lon, 64)
+		got := rgeocoder.HaversineDistance(g.Lat, g.Lon, lat, lon)
+		want := math.Inf(1)
+		for _, c := range known[parityKey(g.Name, g.Admin1, g.Admin2, g.CC)] {
+			want = min(want, rgeocoder.HaversineDistance(g.Lat, g.Lon, c.Lat, c.Lon))
+		}
+		if got > want+1e-6 {
+			t.Errorf("point %d (%v,%v): ECEF picked %s at %.3f km, the degrees-mode %s is closer at %.3f km",
+				i, g.Lat, g.Lon, l.Name, got, g.Name, want)
+		}
+	}
+	// 数据集稀疏且随机点纬度均匀分布，高纬度点较多；差异比例明显升高说明 ECEF 模式出了问题
+	if mismatches > len(golden)/2 {
+		t.Errorf("ECEF mode differs from degrees mode on %d/%d points", mismatches, len(golden))
+	}
+	t.Logf("ECEF mode differs from degrees mode on %d/%d points, all closer on the sphere", mismatches, len(golden))
+}
@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// GeoNames cities1000.txt 格式的样例（19列，制表符分隔）
+var sampleCitiesTxt = strings.Join([]string{
+	"5391959\tSan Francisco\tSan Francisco\tSF\t37.77493\t-122.41942\tP\tPPLA2\tUS\t\tCA\t075\t\t\t864816\t16\t28\tAmerica/Los_Angeles\t2022-02-13",
+	"2643743\tLondon\tLondon\t\t51.50853\t-0.12574\tP\tPPLC\tGB\t\tENG\tGLA\t\t\t8961989\t\t25\tEurope/London\t2023-01-12",
+	"1273874\tKochi\tKochi\t\t9.93988\t76.26020\tP\tPPL\tIN\t\t13\t594\t\t\t604696\t\t5\tAsia/Kolkata\t2021-11-01",
+}, "\n") + "\n"
+
+const sampleAdmin1 = "US.CA\tCalifornia\tCalifornia\t5332921\nGB.ENG\tEngland\tEngland\t6269131\nIN.13\tKerala\tKerala\t1267254\n"
+const sampleAdmin2 = "US.CA.075\tCity and County of San Francisco\tCity and County of San Francisco\t5391997\nGB.ENG.GLA\tGreater London\tGreater London\t2648110\n"
+
+func zipBytes(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func rawGeoNamesFiles(t *testing.T) map[string][]byte {
+	return map[string][]byte{
+		"cities1000.zip":       zipBytes(t, "cities1000.txt", sampleCitiesTxt),
+		"admin1CodesASCII.txt": []byte(sampleAdmin1),
+		"admin2Codes.txt":      []byte(sampleAdmin2),
+	}
+}
+
+func TestProcessGeoNamesData(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range rawGeoNamesFiles(t) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &rgeocoder.Config{DataDir: dir, DownloadURLs: rgeocoder.DefaultURLs}
+	if err := rgeocoder.NewDataProcessor(cfg).ProcessGeoNamesData(); err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, "rg_cities1000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 经纬度按 GeoNames 原文写出（76.26020 不会变成 76.2602），与 Python 版本逐字节一致
+	want := [][]string{
+		{"lat", "lon", "name", "admin1", "admin2", "cc", "geonameid", "population", "feature_class", "feature_code", "timezone", "elevation", "admin1_code", "admin2_code"},
+		{"37.77493", "-122.41942", "San Francisco", "California", "City and County of San Francisco", "US", "5391959", "864816", "P", "PPLA2", "America/Los_Angeles", "16", "CA", "075"},
+		{"51.50853", "-0.12574", "London", "England", "Greater London", "GB", "2643743", "8961989", "P", "PPLC", "Europe/London", "25", "ENG", "GLA"},
+		{"9.93988", "76.26020", "Kochi", "Kerala", "", "IN", "1273874", "604696", "P", "PPL", "Asia/Kolkata", "5", "13", "594"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Fatalf("row %d: got %v want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestProcessGeoNamesDataSkipsBadRows(t *testing.T) {
+	bad := sampleCitiesTxt +
+		"2988507\tParis\tParis\t\t48.85341\t2.3488\tP\tPPLC\tFR\t\t11\t75\t\t\tmany\t\t42\tEurope/Paris\t2023-01-12\n" +
+		"truncated\tline\n"
+	files := rawGeoNamesFiles(t)
+	files["cities1000.zip"] = zipBytes(t, "cities1000.txt", bad)
+	for _, strict := range []bool{false, true} {
+		dir := t.TempDir()
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cfg := &rgeocoder.Config{DataDir: dir, DownloadURLs: rgeocoder.DefaultURLs, StrictLoad: strict}
+		p := rgeocoder.NewDataProcessor(cfg)
+		err := p.ProcessGeoNamesData()
+		if strict {
+			var rowErr *rgeocoder.RowError
+			if !errors.As(err, &rowErr) || rowErr.Line != 4 || rowErr.Reason != rgeocoder.RejectBadInt {
+				t.Fatalf("strict: expected bad_int on line 4, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("lenient: %v", err)
+		}
+		r := p.Report()
+		if r.Rows != 5 || r.Loaded != 3 || r.Rejected != 2 ||
+			r.Reasons[rgeocoder.RejectBadInt] != 1 || r.Reasons[rgeocoder.RejectFieldCount] != 1 {
+			t.Fatalf("unexpected report %+v", r)
+		}
+		if rows := readDataset(t, dir); len(rows) != 3 || rows["Paris"] != nil {
+			t.Fatalf("unexpected dataset %v", rows)
+		}
+	}
+}
+
+func TestParseGeoNamesRecordRejectsShortRows(t *testing.T) {
+	if _, err := rgeocoder.ParseGeoNamesRecord([]string{"1", "x"}); err == nil {
+		t.Fatalf("expected error for short row")
+	}
+}
+
+func TestNewRGeocoderBootstrapsFromMirror(t *testing.T) {
+	skipIfEmbedded(t)
+	m := newMirror(rawGeoNamesFiles(t))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	dir := t.TempDir()
+
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithDownloadURLs(urls))
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	defer rg.Close()
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 51.5214588, Lon: -0.1729636})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if loc.Name != "London" || loc.Admin1 != "England" {
+		t.Fatalf("unexpected result: %+v", loc)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rg_cities1000.csv")); err != nil {
+		t.Fatalf("dataset not written: %v", err)
+	}
+}
+
+func TestDatasetsSideBySide(t *testing.T) {
+	skipIfEmbedded(t)
+	files := rawGeoNamesFiles(t)
+	files["cities15000.zip"] = zipBytes(t, "cities15000.txt", strings.SplitAfter(sampleCitiesTxt, "\n")[1])
+	files["DE.zip"] = zipBytes(t, "DE.txt", geoLine(2950159, "Berlin", 52.52437, 13.41053, "P", "PPLC", "DE", "16", "00", 3426354, "2023-01-10")+"\n"+
+		geoLine(2950160, "Teufelsberg", 52.4975, 13.2411, "T", "HLL", "DE", "16", "00", 0, "2023-01-10")+"\n")
+	files["FR.zip"] = zipBytes(t, "FR.txt", geoLine(3000001, "Hameau", 45.5, 4.5, "P", "PPL", "FR", "84", "", 0, "2023-01-11")+"\n")
+	m := newMirror(files)
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	dir := t.TempDir()
+
+	countries, err := rgeocoder.ParseDataset("countries:de,fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ds    rgeocoder.Dataset
+		file  string
+		names []string
+	}{
+		{rgeocoder.Cities1000, "rg_cities1000.csv", []string{"San Francisco", "London", "Kochi"}},
+		{rgeocoder.Cities15000, "rg_cities15000.csv", []string{"London"}},
+		{countries, "rg_countries-DE-FR.csv", []string{"Berlin", "Hameau"}},
+	}
+	for _, c := range cases {
+		rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithDownloadURLs(urls), rgeocoder.WithDataset(c.ds))
+		if err != nil {
+			t.Fatalf("%s: %v", c.ds.Name, err)
+		}
+		n := rg.Len()
+		rg.Close()
+		if n != len(c.names) {
+			t.Fatalf("%s: expected %d locations, got %d", c.ds.Name, len(c.names), n)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range c.names {
+			if !strings.Contains(string(data), ","+name+",") {
+				t.Fatalf("%s: %s missing from %s", c.ds.Name, name, c.file)
+			}
+		}
+	}
+	// 之前生成的数据集不受影响
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	if rg.Len() != 3 {
+		t.Fatalf("cities1000 dataset changed: %d locations", rg.Len())
+	}
+	if _, err := rgeocoder.ParseDataset("cities2000"); err == nil {
+		t.Fatalf("expected error for unknown dataset")
+	}
+}
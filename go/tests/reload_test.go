@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+func TestReloadSwapsConsistently(t *testing.T) {
+	r := rand.New(rand.NewSource(21))
+	var hookErrs []error
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(randomCSV(r, 2000, "old")),
+		rgeocoder.WithReloadHook(func(err error) { hookErrs = append(hookErrs, err) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	queries := randomCoords(r, 500, -90, 90)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				res, err := rg.QueryDetailed(queries)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// 一次查询内的结果来自同一份数据
+				prefix := res[0].Location.Name[:3]
+				for _, q := range res {
+					if !strings.HasPrefix(q.Location.Name, prefix) {
+						t.Errorf("mixed datasets in one query: %s and %s", prefix, q.Location.Name)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		name := []string{"new", "old"}[i%2]
+		if err := rg.Reload(rgeocoder.ReaderSource(strings.NewReader(randomCSV(r, 2000, name)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	loc, err := rg.QuerySingle(queries[0])
+	if err != nil || !strings.HasPrefix(loc.Name, "new") {
+		t.Fatalf("expected last reload to win, got %+v %v", loc, err)
+	}
+	// 加载失败时保留当前数据
+	if err := rg.Reload(rgeocoder.FileSource(filepath.Join(t.TempDir(), "missing.csv"))); err == nil {
+		t.Fatalf("expected reload error")
+	}
+	if again, _ := rg.QuerySingle(queries[0]); again.Name != loc.Name {
+		t.Fatalf("failed reload replaced data: %+v", again)
+	}
+	if len(hookErrs) != 6 || hookErrs[4] != nil || hookErrs[5] == nil {
+		t.Fatalf("unexpected hook calls: %v", hookErrs)
+	}
+}
+
+func TestReloadFromSnapshotFile(t *testing.T) {
+	src, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10,Snap,,,AA\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	path := filepath.Join(t.TempDir(), "next.snap")
+	if err := src.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, mmap := range []bool{false, true} {
+		rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(loaderCSV), rgeocoder.WithMmap(mmap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rg.Reload(rgeocoder.FileSource(path)); err != nil {
+			t.Fatal(err)
+		}
+		loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 51, Lon: 0})
+		rg.Close()
+		if err != nil || loc.Name != "Snap" {
+			t.Fatalf("mmap=%v: got %+v %v", mmap, loc, err)
+		}
+	}
+}
+
+func TestIndexHeldAcrossReloadAndClose(t *testing.T) {
+	dir := t.TempDir()
+	snap := func(name string) string {
+		src, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10," + name + ",,,AA\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		path := filepath.Join(dir, name+".snap")
+		if err := src.SaveSnapshot(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first, second := snap("First"), snap("Second")
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(loaderCSV), rgeocoder.WithMmap(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rg.Reload(rgeocoder.FileSource(first)); err != nil {
+		t.Fatal(err)
+	}
+	ix, release, err := rg.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 持有的映射索引在 Reload 与 Close 之后仍可用，直到 release
+	if err := rg.Reload(rgeocoder.FileSource(second)); err != nil {
+		t.Fatal(err)
+	}
+	if loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 9, Lon: 9}); err != nil || loc.Name != "Second" {
+		t.Fatalf("after reload: got %+v %v", loc, err)
+	}
+	if err := rg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 9, Lon: 9}); !errors.Is(err, rgeocoder.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := rg.Reload(rgeocoder.FileSource(first)); !errors.Is(err, rgeocoder.ErrClosed) {
+		t.Fatalf("expected ErrClosed from Reload, got %v", err)
+	}
+	if m, err := ix.QuerySingle(rgeocoder.Coordinate{Lat: 9, Lon: 9}); err != nil || m.Item.Name != "First" {
+		t.Fatalf("held index: got %+v %v", m, err)
+	}
+	release()
+	if rg.Len() != 0 {
+		t.Fatalf("closed geocoder reports %d locations", rg.Len())
+	}
+}
+
+func TestReloadLoadsWithoutBlockingClose(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(loaderCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var built *rgeocoder.RGeocoder
+	slow := func(cfg *rgeocoder.Config) (*rgeocoder.RGeocoder, error) {
+		close(started)
+		<-unblock
+		next, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10,Slow,,,AA\n"))
+		built = next
+		return next, err
+	}
+	done := make(chan error)
+	go func() { done <- rg.Reload(slow) }()
+	<-started
+
+	// 慢加载进行中：其他 Reload 与 Close 不等待它
+	if err := rg.Reload(rgeocoder.ReaderSource(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10,Fast,,,AA\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 10, Lon: 10}); err != nil || loc.Name != "Fast" {
+		t.Fatalf("after concurrent reload: got %+v %v", loc, err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		rg.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on an in-progress Reload")
+	}
+
+	// 加载完成时已关闭：新数据被丢弃并关闭
+	close(unblock)
+	if err := <-done; !errors.Is(err, rgeocoder.ErrClosed) {
+		t.Fatalf("expected ErrClosed from the slow reload, got %v", err)
+	}
+	if _, err := built.QuerySingle(rgeocoder.Coordinate{Lat: 10, Lon: 10}); !errors.Is(err, rgeocoder.ErrClosed) {
+		t.Fatalf("discarded geocoder still open: %v", err)
+	}
+}
+
+func TestWatchReloadsChangedDataset(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "rg_cities1000.csv")
+	write := func(name string, mtime time.Time) {
+		t.Helper()
+		data := fmt.Sprintf("lat,lon,name,admin1,admin2,cc\n10,10,%s,,,AA\n", name)
+		if err := os.WriteFile(csvPath, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(csvPath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("First", time.Now().Add(-time.Hour))
+
+	reloaded := make(chan error, 4)
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false),
+		rgeocoder.WithWatch(10*time.Millisecond), rgeocoder.WithReloadHook(func(err error) { reloaded <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+
+	write("Second", time.Now())
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher did not reload")
+	}
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 10, Lon: 10})
+	if err != nil || loc.Name != "Second" {
+		t.Fatalf("unexpected result after reload: %+v %v", loc, err)
+	}
+}
@@ -0,0 +1,338 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// randomCSV 生成随机数据集，名称带序号便于比对
+func randomCSV(r *rand.Rand, n int, prefix string) string {
+	var sb strings.Builder
+	sb.WriteString("lat,lon,name,admin1,admin2,cc\n")
+	for i, c := range randomCoords(r, n, -90, 90) {
+		fmt.Fprintf(&sb, "%.5f,%.5f,%s%d,Region %d,,X%d\n", c.Lat, c.Lon, prefix, i, i%7, i%3)
+	}
+	return sb.String()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	csvData := randomCSV(r, 3000, "city")
+	queries := randomCoords(r, 200, -90, 90)
+	modes := map[string]rgeocoder.DistanceMode{
+		"haversine": rgeocoder.DistanceHaversine,
+		"euclidean": rgeocoder.DistanceEuclideanDegrees,
+		"ecef":      rgeocoder.DistanceECEF,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(csvData),
+				rgeocoder.WithDistanceMode(mode), rgeocoder.WithMode(rgeocoder.SingleThreaded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "rg.snap")
+			if err := rg.SaveSnapshot(path); err != nil {
+				t.Fatalf("save failed: %v", err)
+			}
+			loaded, err := rgeocoder.LoadSnapshot(path)
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer loaded.Close()
+			want, err := rg.QueryK(queries, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := loaded.QueryK(queries, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range want {
+				for j := range want[i] {
+					if !reflect.DeepEqual(got[i][j], want[i][j]) {
+						t.Fatalf("query %d neighbour %d: got %+v want %+v", i, j, got[i][j], want[i][j])
+					}
+				}
+			}
+			inBox, err := loaded.QueryBBox(10, 10, 40, 60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBox, _ := rg.QueryBBox(10, 10, 40, 60)
+			if len(inBox) != len(wantBox) {
+				t.Fatalf("bbox: got %d want %d", len(inBox), len(wantBox))
+			}
+		})
+	}
+}
+
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(randomCSV(rand.New(rand.NewSource(1)), 100, "c")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	path := filepath.Join(t.TempDir(), "rg.snap")
+	if err := rg.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]func(b []byte){
+		"body":    func(b []byte) { b[len(b)-3] ^= 0xff },
+		"version": func(b []byte) { b[8] = 99 },
+		"magic":   func(b []byte) { b[0] = 'X' },
+		"zeroed":  func(b []byte) { clear(b[len(b)/2:]) },
+	}
+	for name, corrupt := range cases {
+		b := bytes.Clone(orig)
+		corrupt(b)
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rgeocoder.LoadSnapshot(path); !errors.Is(err, rgeocoder.ErrBadSnapshot) {
+			t.Fatalf("%s: expected ErrBadSnapshot, got %v", name, err)
+		}
+	}
+}
+
+func TestNewRGeocoderPrefersFreshSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "rg_cities1000.csv")
+	oldCSV := "lat,lon,name,admin1,admin2,cc\n10,10,FromSnapshot,,,AA\n"
+	newCSV := "lat,lon,name,admin1,admin2,cc\n10,10,FromCSV,,,BB\n"
+	if err := os.WriteFile(csvPath, []byte(oldCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rg.SaveSnapshot(rgeocoder.DefaultSnapshotPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	rg.Close()
+
+	nameAt := func(opts ...rgeocoder.Option) string {
+		t.Helper()
+		opts = append(opts, rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+		rg, err := rgeocoder.NewRGeocoder(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rg.Close()
+		loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 10, Lon: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc.Name
+	}
+
+	// 只改动修改时间：内容与快照记录的一致，仍使用快照
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(csvPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got := nameAt(); got != "FromSnapshot" {
+		t.Fatalf("expected snapshot to be used for touched CSV, got %s", got)
+	}
+	// 大小相同但内容不同：修改时间与记录不一致，按哈希判断
+	sameSize := strings.Replace(oldCSV, "FromSnapshot", "FromCSVSized", 1)
+	if err := os.WriteFile(csvPath, []byte(sameSize), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := nameAt(); got != "FromCSVSized" {
+		t.Fatalf("expected same-size CSV with other content to be used, got %s", got)
+	}
+	// CSV 内容变化但修改时间早于快照（如从备份恢复）：快照记录的大小与哈希不符，回退到 CSV
+	if err := os.WriteFile(csvPath, []byte(newCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(csvPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if got := nameAt(); got != "FromCSV" {
+		t.Fatalf("expected snapshot of another dataset to be ignored, got %s", got)
+	}
+	// 距离模式不一致时回退到 CSV
+	if got := nameAt(rgeocoder.WithDistanceMode(rgeocoder.DistanceECEF)); got != "FromCSV" {
+		t.Fatalf("expected CSV for mismatched distance mode, got %s", got)
+	}
+
+	// 来源未知的快照（从流加载或修改过的数据）按修改时间判断
+	for _, mutate := range []bool{false, true} {
+		var rg *rgeocoder.RGeocoder
+		if mutate {
+			rg, err = rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false))
+		} else {
+			rg, err = rgeocoder.NewRGeocoderWithStream(strings.NewReader(oldCSV))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mutate {
+			if err := rg.Update(0, rgeocoder.Coordinate{Lat: 10, Lon: 10}, rgeocoder.Location{Name: "FromSnapshot"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rg.SaveSnapshot(rgeocoder.DefaultSnapshotPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+		rg.Close()
+		if err := os.Chtimes(csvPath, past, past); err != nil {
+			t.Fatal(err)
+		}
+		if got := nameAt(); got != "FromSnapshot" {
+			t.Fatalf("mutate=%v: expected snapshot over older CSV, got %s", mutate, got)
+		}
+		if err := os.Chtimes(csvPath, future, future); err != nil {
+			t.Fatal(err)
+		}
+		if got := nameAt(); got != "FromCSV" {
+			t.Fatalf("mutate=%v: expected stale snapshot to be ignored, got %s", mutate, got)
+		}
+	}
+}
+
+func TestOpenMappedMatchesInMemory(t *testing.T) {
+	r := rand.New(rand.NewSource(21))
+	csvData := randomCSV(r, 3000, "city")
+	queries := randomCoords(r, 5000, -90, 90)
+	for _, mode := range []rgeocoder.DistanceMode{rgeocoder.DistanceHaversine, rgeocoder.DistanceECEF} {
+		rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(csvData), rgeocoder.WithDistanceMode(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rg.Close()
+		path := filepath.Join(t.TempDir(), "rg.snap")
+		if err := rg.SaveSnapshot(path); err != nil {
+			t.Fatal(err)
+		}
+		mapped, err := rgeocoder.OpenMapped(path, rgeocoder.WithMaxWorkers(4))
+		if err != nil {
+			t.Fatalf("open mapped failed: %v", err)
+		}
+		want, err := rg.QueryDetailed(queries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := mapped.QueryDetailed(queries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Fatalf("mode %d query %d: got %+v want %+v", mode, i, got[i], want[i])
+			}
+		}
+		wantN, _ := rg.QueryRadius(queries[0], 1500, 0)
+		gotN, err := mapped.QueryRadius(queries[0], 1500, 0)
+		if err != nil || len(gotN) != len(wantN) {
+			t.Fatalf("radius: got %d want %d (%v)", len(gotN), len(wantN), err)
+		}
+		if err := mapped.Close(); err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+		// 返回的字符串不引用映射内存
+		if got[0].Location.Name != want[0].Location.Name {
+			t.Fatalf("location changed after close")
+		}
+	}
+}
+
+func TestNewRGeocoderWithMmap(t *testing.T) {
+	dir := t.TempDir()
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10,Mapped,,,AA\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	if err := rg.SaveSnapshot(rgeocoder.DefaultSnapshotPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithAutoDownload(false),
+		rgeocoder.WithMmap(true), rgeocoder.WithMode(rgeocoder.SingleThreaded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	loc, err := mapped.QuerySingle(rgeocoder.Coordinate{Lat: 9, Lon: 9})
+	if err != nil || loc.Name != "Mapped" {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+
+	// 结构损坏的文件在映射时即被拒绝
+	bad := filepath.Join(dir, "bad.snap")
+	if err := os.WriteFile(bad, []byte("RGEOSNAP"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rgeocoder.OpenMapped(bad); !errors.Is(err, rgeocoder.ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot, got %v", err)
+	}
+}
+
+func TestSnapshotGzip(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(randomCSV(rand.New(rand.NewSource(2)), 500, "gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	path := filepath.Join(t.TempDir(), "rg.snap.gz")
+	if err := rg.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := rgeocoder.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("load gzip snapshot failed: %v", err)
+	}
+	defer loaded.Close()
+	q := rgeocoder.Coordinate{Lat: 12, Lon: 34}
+	want, _ := rg.QuerySingle(q)
+	got, err := loaded.QuerySingle(q)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v (%v)", got, want, err)
+	}
+}
+
+func TestSnapshotKeepsGeoNamesFields(t *testing.T) {
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader(extendedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	path := filepath.Join(t.TempDir(), "rg.snap")
+	if err := rg.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := rg.QuerySingle(rgeocoder.Coordinate{Lat: 51.5, Lon: -0.1})
+	for _, mmap := range []bool{false, true} {
+		var loaded *rgeocoder.RGeocoder
+		if mmap {
+			loaded, err = rgeocoder.OpenMapped(path)
+		} else {
+			loaded, err = rgeocoder.LoadSnapshot(path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loaded.QuerySingle(rgeocoder.Coordinate{Lat: 51.5, Lon: -0.1})
+		loaded.Close()
+		if err != nil || !reflect.DeepEqual(got, want) || got.GeoNameID != 2643743 {
+			t.Fatalf("mmap=%v: got %+v want %+v (%v)", mmap, got, want, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-username/reverse-geocoder-go/pkg/rgeocoder"
+)
+
+// publish 替换镜像上的 cities1000.zip
+func (m *mirror) publish(t *testing.T, cities string) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files["cities1000.zip"] = zipBytes(t, "cities1000.txt", cities)
+}
+
+func TestUpdaterValidatesBeforeSwap(t *testing.T) {
+	skipIfEmbedded(t)
+	m := newMirror(rawGeoNamesFiles(t))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	dir := t.TempDir()
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithDownloadURLs(urls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	london := rgeocoder.Coordinate{Lat: 51.5, Lon: -0.1}
+	nameAt := func() string {
+		t.Helper()
+		loc, err := rg.QuerySingle(london)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc.Name
+	}
+
+	u := rgeocoder.NewUpdater(rg)
+	u.Policy = rgeocoder.UpdatePolicy{MinRows: 3, MaxCountryDelta: 0.5, MinCountryRows: 1}
+
+	// 镜像未变化：条件请求全部 304，不重新生成
+	res, err := u.UpdateNow(context.Background())
+	if err != nil || res.Changed {
+		t.Fatalf("expected no change, got %+v", res)
+	}
+	if len(m.conditional) != 3 {
+		t.Fatalf("expected conditional requests for all 3 files, got %v", m.conditional)
+	}
+
+	// 通过校验：换入并写入 DataDir
+	m.publish(t, strings.ReplaceAll(sampleCitiesTxt, "\tLondon\tLondon\t", "\tCity of London\tCity of London\t"))
+	res, err = u.UpdateNow(context.Background())
+	if err != nil || !res.Changed || res.Rows != 3 {
+		t.Fatalf("expected successful update, got %+v", res)
+	}
+	if got := nameAt(); got != "City of London" {
+		t.Fatalf("update not swapped in: %s", got)
+	}
+	csvData, err := os.ReadFile(filepath.Join(dir, "rg_cities1000.csv"))
+	if err != nil || !strings.Contains(string(csvData), "City of London") {
+		t.Fatalf("dataset in data dir not updated: %v", err)
+	}
+
+	// 某个国家的行数骤降：拒绝并保留旧数据
+	lines := strings.Split(strings.TrimSpace(sampleCitiesTxt), "\n")
+	withoutGB := lines[0] + "\n" + lines[2] + "\n" + strings.Replace(lines[2], "Kochi", "Kochi East", 2) + "\n"
+	m.publish(t, withoutGB)
+	res, err = u.UpdateNow(context.Background())
+	var verr *rgeocoder.ValidationError
+	if !errors.As(err, &verr) || res.Changed {
+		t.Fatalf("expected validation error, got %+v", res)
+	}
+	if !strings.Contains(verr.Error(), "country GB: 1 -> 0") || !strings.Contains(verr.Error(), "country IN: 1 -> 2") {
+		t.Fatalf("unexpected problems: %v", verr.Problems)
+	}
+	if got := nameAt(); got != "City of London" {
+		t.Fatalf("rejected dataset was swapped in: %s", got)
+	}
+	if u.LastResult() == nil || u.LastResult().Err == nil {
+		t.Fatalf("last result should record the failure")
+	}
+
+	// 行数不足
+	m.publish(t, lines[1]+"\n")
+	if _, err := u.UpdateNow(context.Background()); !errors.As(err, &verr) || !strings.Contains(err.Error(), "minimum is 3") {
+		t.Fatalf("expected min rows failure, got %v", err)
+	}
+
+	// 下载失败
+	m.mu.Lock()
+	delete(m.files, "admin2Codes.txt")
+	m.mu.Unlock()
+	if _, err := u.UpdateNow(context.Background()); err == nil {
+		t.Fatalf("expected download error")
+	}
+	if got := nameAt(); got != "City of London" {
+		t.Fatalf("failed download changed data: %s", got)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dir, ".update-*")); len(entries) != 0 {
+		t.Fatalf("staging directories left behind: %v", entries)
+	}
+}
+
+func TestUpdaterDefaultPolicyScalesToDataset(t *testing.T) {
+	skipIfEmbedded(t)
+	m := newMirror(rawGeoNamesFiles(t))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(t.TempDir()), rgeocoder.WithDownloadURLs(urls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	u := rgeocoder.NewUpdater(rg)
+
+	// 只有 3 行的数据集按当前规模校验，不会因为固定的最少行数被拒绝
+	m.publish(t, strings.ReplaceAll(sampleCitiesTxt, "\tKochi\tKochi\t", "\tCochin\tCochin\t"))
+	if res, err := u.UpdateNow(context.Background()); err != nil || !res.Changed || res.Rows != 3 {
+		t.Fatalf("expected small dataset update, got %+v", res)
+	}
+
+	// 行数骤减仍被拒绝
+	lines := strings.Split(strings.TrimSpace(sampleCitiesTxt), "\n")
+	m.publish(t, lines[1]+"\n")
+	var verr *rgeocoder.ValidationError
+	if _, err := u.UpdateNow(context.Background()); !errors.As(err, &verr) || !strings.Contains(err.Error(), "1 rows, minimum is 2") {
+		t.Fatalf("expected min rows failure, got %v", err)
+	}
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 9.9, Lon: 76.3})
+	if err != nil || loc.Name != "Cochin" {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+}
+
+func TestUpdaterInstallsForWatcher(t *testing.T) {
+	skipIfEmbedded(t)
+	m := newMirror(rawGeoNamesFiles(t))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	dir := t.TempDir()
+	reloaded := make(chan error, 8)
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(dir), rgeocoder.WithDownloadURLs(urls),
+		rgeocoder.WithWatch(10*time.Millisecond), rgeocoder.WithReloadHook(func(err error) { reloaded <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	u := rgeocoder.NewUpdater(rg)
+	marker := filepath.Join(dir, "rg_cities1000.installing")
+
+	// 更新器只安装文件，由监视器加载一次
+	m.publish(t, strings.ReplaceAll(sampleCitiesTxt, "\tKochi\tKochi\t", "\tCochin\tCochin\t"))
+	if res, err := u.UpdateNow(context.Background()); err != nil || !res.Changed {
+		t.Fatalf("expected update, got %+v", res)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("install marker left behind: %v", err)
+	}
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher did not reload")
+	}
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 9.9, Lon: 76.3})
+	if err != nil || loc.Name != "Cochin" {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+	select {
+	case <-reloaded:
+		t.Fatalf("dataset reloaded twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 中断的安装：源文件未变化也重新生成
+	if err := os.WriteFile(marker, []byte("rg_cities1000.csv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := u.UpdateNow(context.Background()); err != nil || !res.Changed {
+		t.Fatalf("expected regeneration after interrupted install, got %+v", res)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("install marker left behind: %v", err)
+	}
+}
+
+func TestUpdaterSchedule(t *testing.T) {
+	m := newMirror(rawGeoNamesFiles(t))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	rg, err := rgeocoder.NewRGeocoder(rgeocoder.WithDataDir(t.TempDir()), rgeocoder.WithDownloadURLs(urls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+
+	m.publish(t, strings.ReplaceAll(sampleCitiesTxt, "\tKochi\tKochi\t", "\tCochin\tCochin\t"))
+	results := make(chan rgeocoder.UpdateResult, 16)
+	u := rgeocoder.NewUpdater(rg)
+	u.Policy = rgeocoder.UpdatePolicy{MinRows: 1}
+	u.OnResult = func(res rgeocoder.UpdateResult) { results <- res }
+	u.Start(10 * time.Millisecond)
+	defer u.Stop()
+	select {
+	case res := <-results:
+		if res.Err != nil || !res.Changed {
+			t.Fatalf("unexpected scheduled result: %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("scheduled update did not run")
+	}
+	loc, err := rg.QuerySingle(rgeocoder.Coordinate{Lat: 9.9, Lon: 76.3})
+	if err != nil || loc.Name != "Cochin" {
+		t.Fatalf("unexpected result %+v %v", loc, err)
+	}
+}
+
+func TestUpdaterStopAbortsStalledDownload(t *testing.T) {
+	hit := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case hit <- struct{}{}:
+		default:
+		}
+		// 模拟卡住的连接：直到客户端放弃才返回
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	urls := rgeocoder.DefaultURLs
+	urls.BaseURL = srv.URL + "/dump/"
+	rg, err := rgeocoder.NewRGeocoderWithStream(strings.NewReader("lat,lon,name,admin1,admin2,cc\n10,10,Town,,,AA\n"),
+		rgeocoder.WithDataDir(t.TempDir()), rgeocoder.WithDownloadURLs(urls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+
+	u := rgeocoder.NewUpdater(rg)
+	u.Start(time.Millisecond)
+	select {
+	case <-hit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updater never requested the mirror")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		u.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a stalled download")
+	}
+	if res := u.LastResult(); res == nil || !errors.Is(res.Err, context.Canceled) {
+		t.Fatalf("expected canceled update, got %+v", res)
+	}
+}
@@ -15,6 +15,11 @@ parity_random_100.py
 
 运行：
   python parity_random_100.py
+  python parity_random_100.py --golden go/tests/testdata/parity_golden.jsonl
+    (只写出 Python 结果作为 Go 端 parity 测试的黄金数据，不需要 Go 服务)
+  python parity_random_100.py --golden go/tests/testdata/parity_golden.jsonl go/tests/testdata/parity_cities.csv
+    (在指定的数据集上生成黄金数据。必须安装 reverse_geocoder（及 scipy），否则直接失败；
+     同时写出 parity_golden.meta.json，记录库与 scipy 的版本)
 
 退出码:
   0 -> 全部匹配
@@ -34,8 +39,7 @@ import urllib.error
 try:
     import reverse_geocoder as rg
 except ImportError:
-    print("ERROR: missing reverse_geocoder. Install with: pip install reverse_geocoder", file=sys.stderr)
-    sys.exit(1)
+    rg = None
 
 GO_SERVER = os.environ.get("GO_SERVER", "http://localhost:8080")
 SAMPLES = 100
@@ -56,6 +60,16 @@ def query_python(lat: float, lon: float) -> Dict:
         return {}
     return res[0]
 
+def dataset_searcher(path: str):
+    """返回用 reverse_geocoder 在 path 数据集上查询最近位置的函数"""
+    with open(path, encoding='utf-8', newline='') as f:
+        geo = rg.RGeocoder(mode=1, verbose=False, stream=f)
+    return lambda lat, lon: geo.query([(lat, lon)])[0]
+
+def package_version(name: str) -> str:
+    from importlib.metadata import version
+    return version(name)
+
 def query_go(lat: float, lon: float) -> Dict:
     url = f"{GO_SERVER}/reverse?lat={lat}&lon={lon}"
     with urllib.request.urlopen(url, timeout=5) as resp:
@@ -73,7 +87,40 @@ def compare(go_rec: Dict, py_rec: Dict):
             diffs.append(f"{f}: go='{go_rec.get(f)}' python='{py_rec.get(f)}'")
     return diffs
 
+def write_golden(path: str, dataset: str = None) -> int:
+    search = dataset_searcher(dataset) if dataset else query_python
+    with open(path, 'w', encoding='utf-8') as out:
+        for _ in range(SAMPLES):
+            lat, lon = rand_coord()
+            rec = search(lat, lon)
+            row = {"lat": lat, "lon": lon}
+            row.update({f: rec.get(f) for f in FIELDS})
+            out.write(json.dumps(row, ensure_ascii=False) + "\n")
+    meta = {
+        "generator": "reverse_geocoder.RGeocoder(mode=1)" if dataset else "reverse_geocoder.search(mode=1)",
+        "reverse_geocoder": package_version("reverse_geocoder"),
+        "scipy": package_version("scipy"),
+        "seed": RANDOM_SEED,
+        "samples": SAMPLES,
+        "dataset": os.path.basename(dataset) if dataset else "",
+    }
+    meta_path = os.path.splitext(path)[0] + ".meta.json"
+    with open(meta_path, 'w', encoding='utf-8') as out:
+        json.dump(meta, out, indent=2)
+        out.write("\n")
+    print(f"wrote {SAMPLES} golden rows to {path} (reverse_geocoder {meta['reverse_geocoder']})")
+    return 0
+
 def main():
+    if len(sys.argv) in (3, 4) and sys.argv[1] == '--golden':
+        if rg is None:
+            print("ERROR: missing reverse_geocoder; golden data must come from the library. "
+                  "Install with: pip install reverse_geocoder", file=sys.stderr)
+            return 1
+        return write_golden(*sys.argv[2:])
+    if rg is None:
+        print("ERROR: missing reverse_geocoder. Install with: pip install reverse_geocoder", file=sys.stderr)
+        return 1
     start = time.time()
     mismatches = 0
     tested = 0